@@ -0,0 +1,215 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// accessTracker records when each key was last read or written, and how often. The
+// enabled flags are read atomically, so that reads do not take the lock while both
+// kinds of tracking are disabled.
+type accessTracker struct {
+	mutex        sync.Mutex
+	enabled      int32 // set atomically
+	accessed     map[string]time.Time
+	usageEnabled int32 // set atomically
+	usage        map[string]KeyUsage
+}
+
+// KeyUsage counts the reads and writes of a key, as reported by Usage.
+type KeyUsage struct {
+	Reads  uint64
+	Writes uint64
+}
+
+// record notes that key has just been read or written, if tracking is enabled.
+func (a *accessTracker) record(key string, write bool) {
+	tracking := atomic.LoadInt32(&a.enabled) != 0
+	counting := atomic.LoadInt32(&a.usageEnabled) != 0
+	if !tracking && !counting {
+		return
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if tracking {
+		if a.accessed == nil {
+			a.accessed = make(map[string]time.Time)
+		}
+		a.accessed[key] = time.Now()
+	}
+
+	if counting {
+		if a.usage == nil {
+			a.usage = make(map[string]KeyUsage)
+		}
+		usage := a.usage[key]
+		if write {
+			usage.Writes++
+		} else {
+			usage.Reads++
+		}
+		a.usage[key] = usage
+	}
+}
+
+// load replaces the recorded access times and usage with those read from disk.
+func (a *accessTracker) load(accessed map[string]time.Time, usage map[string]KeyUsage) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.accessed = accessed
+	a.usage = usage
+}
+
+// snapshot returns copies of the recorded access times and usage, for writing to disk.
+// Records for keys that no longer exist in data are discarded.
+func (a *accessTracker) snapshot(data interface{}) (map[string]time.Time, map[string]KeyUsage) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	sharded, ok := data.(shardedData)
+	exists := func(key string) bool {
+		if !ok {
+			return true
+		}
+		_, exists := sharded.shardFor(key).get()[key]
+		return exists
+	}
+
+	var accessed map[string]time.Time
+	if len(a.accessed) > 0 {
+		accessed = make(map[string]time.Time, len(a.accessed))
+		for key, t := range a.accessed {
+			if exists(key) {
+				accessed[key] = t
+			} else {
+				delete(a.accessed, key)
+			}
+		}
+	}
+
+	var usage map[string]KeyUsage
+	if len(a.usage) > 0 {
+		usage = make(map[string]KeyUsage, len(a.usage))
+		for key, u := range a.usage {
+			if exists(key) {
+				usage[key] = u
+			} else {
+				delete(a.usage, key)
+			}
+		}
+	}
+
+	return accessed, usage
+}
+
+// SetAccessTracking controls whether the Stash records when each key was last read or
+// written, for use by RecentKeys and LastAccessed. Access times are saved with the data,
+// so they survive a restart. Tracking is disabled by default; times recorded previously
+// are kept while it is disabled.
+func (s *Stash) SetAccessTracking(enabled bool) {
+	atomic.StoreInt32(&s.access.enabled, boolToInt32(enabled))
+}
+
+// LastAccessed returns the time key was last read or written while access tracking was
+// enabled. If no access has been recorded, ok is false.
+func (s *Stash) LastAccessed(key string) (t time.Time, ok bool) {
+	s.access.mutex.Lock()
+	defer s.access.mutex.Unlock()
+	t, ok = s.access.accessed[key]
+	return t, ok
+}
+
+// RecentKeys returns up to n existing keys, ordered from the most to the least recently
+// accessed. Only accesses recorded while access tracking was enabled are considered. If
+// n is zero or less, all such keys are returned.
+func (s *Stash) RecentKeys(n int) []string {
+	accessed, _ := s.access.snapshot(s.data)
+
+	keys := make([]string, 0, len(accessed))
+	for key := range accessed {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ti, tj := accessed[keys[i]], accessed[keys[j]]
+		if ti.Equal(tj) {
+			return keys[i] < keys[j]
+		}
+		return ti.After(tj)
+	})
+
+	if n > 0 && len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+// SetUsageStats controls whether the Stash counts the reads and writes of each key, for
+// use by Usage and HotKeys. Counts are saved with the data, so they survive a restart.
+// Counting is disabled by default; counts recorded previously are kept while it is
+// disabled.
+func (s *Stash) SetUsageStats(enabled bool) {
+	atomic.StoreInt32(&s.access.usageEnabled, boolToInt32(enabled))
+}
+
+// Usage returns the number of reads and writes of key counted while usage statistics
+// were enabled.
+func (s *Stash) Usage(key string) KeyUsage {
+	s.access.mutex.Lock()
+	defer s.access.mutex.Unlock()
+	return s.access.usage[key]
+}
+
+// HotKeys returns up to n existing keys, ordered from the most to the least used, where
+// use is the total of reads and writes counted while usage statistics were enabled. If n
+// is zero or less, all keys with counted use are returned.
+func (s *Stash) HotKeys(n int) []string {
+	_, usage := s.access.snapshot(s.data)
+
+	keys := make([]string, 0, len(usage))
+	for key := range usage {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ui, uj := usage[keys[i]], usage[keys[j]]
+		if ui.Reads+ui.Writes == uj.Reads+uj.Writes {
+			return keys[i] < keys[j]
+		}
+		return ui.Reads+ui.Writes > uj.Reads+uj.Writes
+	})
+
+	if n > 0 && len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+// boolToInt32 returns 1 if b is true and 0 otherwise, for storing flags atomically.
+func boolToInt32(b bool) int32 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,120 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAccessTracking(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+
+	// Nothing is recorded until tracking is enabled
+	require.Nil(t, s.Save("untracked", 0))
+	_, ok := s.LastAccessed("untracked")
+	require.False(t, ok)
+
+	s.SetAccessTracking(true)
+	for _, key := range []string{"a", "b", "c"} {
+		require.Nil(t, s.Save(key, key))
+		time.Sleep(time.Millisecond)
+	}
+
+	var value string
+	require.Nil(t, s.Read("a", &value))
+
+	require.Equal(t, []string{"a", "c", "b"}, s.RecentKeys(0))
+	require.Equal(t, []string{"a", "c"}, s.RecentKeys(2))
+
+	last, ok := s.LastAccessed("a")
+	require.True(t, ok)
+	require.False(t, last.IsZero())
+
+	// Access times are persisted
+	require.Nil(t, s.Flush())
+	s, err = NewStash(filename, false)
+	require.Nil(t, err)
+	require.Equal(t, []string{"a", "c", "b"}, s.RecentKeys(0))
+}
+
+func TestUsageStats(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+	s.SetUsageStats(true)
+
+	require.Nil(t, s.Save("a", 1))
+	require.Nil(t, s.Save("b", 2))
+	require.Nil(t, s.Save("c", 3))
+
+	var value int
+	for i := 0; i < 3; i++ {
+		require.Nil(t, s.Read("b", &value))
+	}
+	require.Nil(t, s.Read("c", &value))
+
+	require.Equal(t, KeyUsage{Reads: 3, Writes: 1}, s.Usage("b"))
+	require.Equal(t, []string{"b", "c", "a"}, s.HotKeys(0))
+	require.Equal(t, []string{"b"}, s.HotKeys(1))
+
+	// Counts are persisted
+	require.Nil(t, s.Flush())
+	s, err = NewStash(filename, false)
+	require.Nil(t, err)
+	require.Equal(t, KeyUsage{Reads: 3, Writes: 1}, s.Usage("b"))
+
+	// Nothing more is counted until enabled again
+	require.Nil(t, s.Read("a", &value))
+	require.Equal(t, KeyUsage{Writes: 1}, s.Usage("a"))
+}
+
+func TestReadWithoutTrackingDoesNotLock(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+	require.Nil(t, s.Save("key", "value"))
+
+	s.access.mutex.Lock()
+	defer s.access.mutex.Unlock()
+
+	done := make(chan error)
+	go func() {
+		var value string
+		done <- s.Read("key", &value)
+	}()
+
+	select {
+	case err = <-done:
+		require.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Read waited for the access tracker's lock")
+	}
+}
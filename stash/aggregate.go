@@ -0,0 +1,94 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// sortedEntries returns a snapshot of every entry, along with its keys in sorted order.
+func (s *Stash) sortedEntries() (v1Data, []string, error) {
+	entries, _, keys, err := s.sortedEntriesWithCodecs()
+	return entries, keys, err
+}
+
+// sortedEntriesWithCodecs behaves like sortedEntries, but also returns the codecs of the
+// entries, captured together with them.
+func (s *Stash) sortedEntriesWithCodecs() (v1Data, map[string]string, []string, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, nil, nil, err
+	}
+	data, ok := s.data.(shardedData)
+	if !ok {
+		return nil, nil, nil, UnknownVersionError{s.version}
+	}
+
+	frozen, codecs := s.snapshotWithCodecs(data)
+	entries := frozen.merged()
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return entries, codecs, keys, nil
+}
+
+// CountWhere returns the number of keys for which fn returns true, given the key and
+// its marshalled value.
+func (s *Stash) CountWhere(fn func(key string, raw json.RawMessage) bool) (int, error) {
+	entries, keys, err := s.sortedEntries()
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, key := range keys {
+		if fn(key, entries[key]) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Aggregate combines every entry into a single result, such as a total or maximum. fn is
+// called for each key in sorted order, with the result so far, starting with initial,
+// and the key's marshalled value. It returns the new result. If fn returns an error,
+// Aggregate stops and returns it.
+//
+//	total, err := s.Aggregate(0, func(acc interface{}, key string, raw json.RawMessage) (interface{}, error) {
+//		var n int
+//		err := json.Unmarshal(raw, &n)
+//		return acc.(int) + n, err
+//	})
+func (s *Stash) Aggregate(initial interface{}, fn func(acc interface{}, key string, raw json.RawMessage) (interface{}, error)) (interface{}, error) {
+	entries, keys, err := s.sortedEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	acc := initial
+	for _, key := range keys {
+		if acc, err = fn(acc, key, entries[key]); err != nil {
+			return acc, err
+		}
+	}
+	return acc, nil
+}
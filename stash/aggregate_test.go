@@ -0,0 +1,70 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCountWhereAndAggregate(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+	require.Nil(t, s.Save("order-1", 10))
+	require.Nil(t, s.Save("order-2", 25))
+	require.Nil(t, s.Save("order-3", 5))
+	require.Nil(t, s.Save("name", "shop"))
+
+	n, err := s.CountWhere(func(key string, raw json.RawMessage) bool {
+		return strings.HasPrefix(key, "order-")
+	})
+	require.Nil(t, err)
+	require.Equal(t, 3, n)
+
+	max, err := s.Aggregate(0, func(acc interface{}, key string, raw json.RawMessage) (interface{}, error) {
+		if !strings.HasPrefix(key, "order-") {
+			return acc, nil
+		}
+		var value int
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, err
+		}
+		if value > acc.(int) {
+			return value, nil
+		}
+		return acc, nil
+	})
+	require.Nil(t, err)
+	require.Equal(t, 25, max)
+
+	// Errors stop the aggregation
+	_, err = s.Aggregate(0, func(acc interface{}, key string, raw json.RawMessage) (interface{}, error) {
+		var value int
+		return acc, json.Unmarshal(raw, &value)
+	})
+	require.NotNil(t, err)
+}
@@ -0,0 +1,156 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// aliasTable maps alias names to the keys they refer to. Like the shards, it is an
+// immutable map that is replaced on each change, so resolving an alias never blocks.
+type aliasTable struct {
+	mutex    sync.Mutex   // serialises writers
+	snapshot atomic.Value // holds a map[string]string, which must not be modified once stored
+}
+
+// get returns the current aliases. The result must not be modified.
+func (a *aliasTable) get() map[string]string {
+	aliases, _ := a.snapshot.Load().(map[string]string)
+	return aliases
+}
+
+// load replaces the aliases with those read from disk.
+func (a *aliasTable) load(aliases map[string]string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.snapshot.Store(aliases)
+}
+
+// update replaces the aliases with a copy that has been modified by fn. If fn returns an
+// error, the aliases are left unchanged.
+func (a *aliasTable) update(fn func(aliases map[string]string) error) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	old := a.get()
+	aliases := make(map[string]string, len(old)+1)
+	for alias, target := range old {
+		aliases[alias] = target
+	}
+	if err := fn(aliases); err != nil {
+		return err
+	}
+	a.snapshot.Store(aliases)
+	return nil
+}
+
+// removeTargets removes every alias whose target satisfies fn.
+func (a *aliasTable) removeTargets(fn func(target string) bool) {
+	a.update(func(aliases map[string]string) error {
+		for alias, target := range aliases {
+			if fn(target) {
+				delete(aliases, alias)
+			}
+		}
+		return nil
+	})
+}
+
+// resolve returns the key that key refers to, which is key itself if it is not an alias.
+func (a *aliasTable) resolve(key string) string {
+	if target, ok := a.get()[key]; ok {
+		return target
+	}
+	return key
+}
+
+// Alias makes alias refer to the same entry as target, so that reading or saving alias
+// reads or saves target. If target is itself an alias, the new alias refers to the
+// entry that target refers to. An existing alias is re-pointed at the new target.
+//
+// Alias returns a NoSuchKeyError if target does not exist, and a KeyExistsError if alias
+// is the name of an existing key. If auto-flush is enabled, the alias is persisted to
+// disk immediately.
+func (s *Stash) Alias(alias, target string) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	data, ok := s.data.(shardedData)
+	if !ok {
+		return UnknownVersionError{s.version}
+	}
+
+	err := s.aliases.update(func(aliases map[string]string) error {
+		if resolved, ok := aliases[target]; ok {
+			target = resolved
+		}
+		if _, exists := data.shardFor(target).get()[target]; !exists {
+			return NoSuchKeyError{target}
+		}
+		if _, exists := data.shardFor(alias).get()[alias]; exists || alias == target {
+			return KeyExistsError{alias}
+		}
+
+		aliases[alias] = target
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if s.autoFlush {
+		return s.Flush()
+	}
+	return nil
+}
+
+// Unalias removes alias, leaving the entry it referred to unchanged. It returns a
+// NoSuchKeyError if alias is not an alias. If auto-flush is enabled, the change is
+// persisted to disk immediately.
+func (s *Stash) Unalias(alias string) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	err := s.aliases.update(func(aliases map[string]string) error {
+		if _, ok := aliases[alias]; !ok {
+			return NoSuchKeyError{alias}
+		}
+		delete(aliases, alias)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if s.autoFlush {
+		return s.Flush()
+	}
+	return nil
+}
+
+// Aliases returns a copy of all aliases, mapped to the keys they refer to.
+func (s *Stash) Aliases() map[string]string {
+	result := make(map[string]string)
+	for alias, target := range s.aliases.get() {
+		result[alias] = target
+	}
+	return result
+}
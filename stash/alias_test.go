@@ -0,0 +1,89 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+)
+
+func TestAlias(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+	require.Nil(t, s.Save("config/v7", "seven"))
+	require.Nil(t, s.Save("config/v8", "eight"))
+
+	require.Nil(t, s.Alias("config/current", "config/v7"))
+
+	var value string
+	require.Nil(t, s.Read("config/current", &value))
+	require.Equal(t, "seven", value)
+
+	// Saving through the alias updates the target
+	require.Nil(t, s.Save("config/current", "seven-updated"))
+	require.Nil(t, s.Read("config/v7", &value))
+	require.Equal(t, "seven-updated", value)
+
+	// Aliases of aliases refer to the final target
+	require.Nil(t, s.Alias("latest", "config/current"))
+	require.Equal(t, map[string]string{"config/current": "config/v7", "latest": "config/v7"}, s.Aliases())
+
+	// Re-pointing is persisted
+	require.Nil(t, s.Alias("config/current", "config/v8"))
+	s, err = NewStash(filename, true)
+	require.Nil(t, err)
+	require.Nil(t, s.Read("config/current", &value))
+	require.Equal(t, "eight", value)
+
+	require.Nil(t, s.Unalias("config/current"))
+	_, ok := s.Read("config/current", &value).(NoSuchKeyError)
+	require.True(t, ok)
+
+	_, ok = s.Unalias("config/current").(NoSuchKeyError)
+	require.True(t, ok)
+}
+
+func TestAliasErrors(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+	require.Nil(t, s.Save("a", 1))
+	require.Nil(t, s.Save("b", 2))
+
+	_, ok := s.Alias("x", "missing").(NoSuchKeyError)
+	require.True(t, ok)
+
+	_, ok = s.Alias("b", "a").(KeyExistsError)
+	require.True(t, ok)
+
+	_, ok = s.Alias("a", "a").(KeyExistsError)
+	require.True(t, ok)
+}
+
+func TestKeyExistsErrorString(t *testing.T) {
+	err := KeyExistsError{"foo"}
+	require.Equal(t, "key already exists: foo", err.Error())
+}
@@ -0,0 +1,146 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// archiveSuffix is appended to each key to form its file name in an archive.
+const archiveSuffix = ".json"
+
+// Archive writes the contents of the Stash to w as a gzip-compressed tar archive, with
+// one file per key containing its indented JSON value. Each file is named after its key
+// with a ".json" suffix, so the key "pages/index" becomes "pages/index.json". Archives
+// can be inspected with standard tools and read back with Unarchive.
+func (s *Stash) Archive(w io.Writer) error {
+	entries, keys, err := s.sortedEntries()
+	if err != nil {
+		return err
+	}
+
+	var written int64
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	modTime := time.Now()
+
+	for i, key := range keys {
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, entries[key], "", "  "); err != nil {
+			return errors.Wrapf(err, "failed to format value of key '%s'", key)
+		}
+		indented.WriteByte('\n')
+
+		header := &tar.Header{
+			Name:     key + archiveSuffix,
+			Mode:     0644,
+			Size:     int64(indented.Len()),
+			ModTime:  modTime,
+			Typeflag: tar.TypeReg,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return errors.Wrapf(err, "failed to write archive header for key '%s'", key)
+		}
+		if _, err := tw.Write(indented.Bytes()); err != nil {
+			return errors.Wrapf(err, "failed to write archive entry for key '%s'", key)
+		}
+		written += header.Size
+		s.reportProgress(Progress{Op: "Archive", Processed: i + 1, Total: len(keys), Bytes: written})
+	}
+
+	if err := tw.Close(); err != nil {
+		return errors.Wrap(err, "failed to close archive")
+	}
+	return errors.Wrap(gz.Close(), "failed to close archive")
+}
+
+// Unarchive reads an archive written by Archive from r and saves each entry, overwriting
+// existing values with the same keys. Directories in the archive are ignored; any other
+// file must have a ".json" suffix and contain a JSON value.
+//
+// If auto-flush is enabled, the entries are persisted with a single Flush. If an error
+// occurs, entries read before the error are kept.
+func (s *Stash) Unarchive(r io.Reader) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return errors.Wrap(err, "failed to open archive")
+	}
+	defer gz.Close()
+
+	imported := 0
+	var read int64
+	err = unarchiveEntries(tar.NewReader(gz), func(key string, value json.RawMessage) error {
+		if _, err := s.storeRawIf(s.aliases.resolve(key), value, "", func(bool) bool { return true }); err != nil {
+			return err
+		}
+		imported++
+		read += int64(len(value))
+		s.reportProgress(Progress{Op: "Unarchive", Processed: imported, Total: -1, Bytes: read})
+		return nil
+	})
+
+	if imported > 0 && s.autoFlush {
+		if flushErr := s.Flush(); err == nil {
+			err = flushErr
+		}
+	}
+	return err
+}
+
+// unarchiveEntries calls fn with the key and compacted value of each file in tr.
+func unarchiveEntries(tr *tar.Reader, fn func(key string, value json.RawMessage) error) error {
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "failed to read archive")
+		}
+
+		if header.FileInfo().IsDir() {
+			continue
+		}
+		if !header.FileInfo().Mode().IsRegular() || !strings.HasSuffix(header.Name, archiveSuffix) {
+			return errors.Errorf("unexpected archive entry '%s'", header.Name)
+		}
+		key := strings.TrimSuffix(header.Name, archiveSuffix)
+
+		raw, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read archive entry '%s'", header.Name)
+		}
+
+		var compacted bytes.Buffer
+		if err = json.Compact(&compacted, raw); err != nil {
+			return errors.Wrapf(err, "archive entry '%s' is not valid JSON", header.Name)
+		}
+		if err = fn(key, compacted.Bytes()); err != nil {
+			return err
+		}
+	}
+}
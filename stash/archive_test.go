@@ -0,0 +1,96 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchive(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+	require.Nil(t, s.Save("greeting", "hello"))
+	require.Nil(t, s.Save("pages/index", map[string]int{"views": 3}))
+
+	var buf bytes.Buffer
+	require.Nil(t, s.Archive(&buf))
+
+	// Check the archive is readable by standard tools
+	gz, err := gzip.NewReader(bytes.NewReader(buf.Bytes()))
+	require.Nil(t, err)
+	tr := tar.NewReader(gz)
+	header, err := tr.Next()
+	require.Nil(t, err)
+	require.Equal(t, "greeting.json", header.Name)
+	header, err = tr.Next()
+	require.Nil(t, err)
+	require.Equal(t, "pages/index.json", header.Name)
+	contents, err := ioutil.ReadAll(tr)
+	require.Nil(t, err)
+	require.Equal(t, "{\n  \"views\": 3\n}\n", string(contents))
+
+	otherFile := makeTempFilename()
+	defer os.Remove(otherFile)
+
+	other, err := NewStash(otherFile, true)
+	require.Nil(t, err)
+	require.Nil(t, other.Save("greeting", "goodbye"))
+	require.Nil(t, other.Unarchive(&buf))
+
+	other, err = NewStash(otherFile, false)
+	require.Nil(t, err)
+
+	var greeting string
+	require.Nil(t, other.Read("greeting", &greeting))
+	require.Equal(t, "hello", greeting)
+
+	var page map[string]int
+	require.Nil(t, other.Read("pages/index", &page))
+	require.Equal(t, 3, page["views"])
+}
+
+func TestUnarchiveUnexpectedEntry(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	require.Nil(t, tw.WriteHeader(&tar.Header{Name: "README", Mode: 0644, Size: 2, Typeflag: tar.TypeReg}))
+	_, err = tw.Write([]byte("hi"))
+	require.Nil(t, err)
+	require.Nil(t, tw.Close())
+	require.Nil(t, gz.Close())
+
+	require.NotNil(t, s.Unarchive(&buf))
+}
@@ -0,0 +1,121 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"github.com/pkg/errors"
+)
+
+// SaveAll behaves like calling Save for each key and value in entries, but if
+// auto-flush is enabled, the changes are persisted with a single Flush. Every value is
+// marshalled before any are saved, so if one cannot be marshalled, nothing is saved.
+func (s *Stash) SaveAll(entries map[string]interface{}) error {
+	marshalled := make(v1Data, len(entries))
+	var codecName string // the same for every value, as all use the default codec
+	for key, value := range entries {
+		marshalledData, name, err := s.marshal(value)
+		if err != nil {
+			return errors.Wrapf(err, "error marshalling value of key '%s'", key)
+		}
+		marshalled[s.aliases.resolve(key)] = marshalledData
+		codecName = name
+	}
+
+	return s.applyBatch(marshalled, codecName, nil)
+}
+
+// DeleteAll behaves like calling Delete for each of the keys, except that keys which do
+// not exist are ignored. If auto-flush is enabled and any keys were removed, the changes
+// are persisted with a single Flush.
+func (s *Stash) DeleteAll(keys []string) error {
+	deleted := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		deleted[s.aliases.resolve(key)] = true
+	}
+
+	return s.applyBatch(nil, "", deleted)
+}
+
+// applyBatch stores the values in saved, which were all encoded with the named codec, and
+// removes the keys in deleted, making a single change to each affected shard, then
+// flushes once if auto-flush is enabled.
+func (s *Stash) applyBatch(saved v1Data, codecName string, deleted map[string]bool) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	data, ok := s.data.(shardedData)
+	if !ok {
+		return UnknownVersionError{s.version}
+	}
+
+	type shardBatch struct {
+		saved   v1Data
+		deleted []string
+	}
+	batches := make(map[*shard]*shardBatch)
+	batchFor := func(key string) *shardBatch {
+		sh := data.shardFor(key)
+		if batches[sh] == nil {
+			batches[sh] = &shardBatch{saved: v1Data{}}
+		}
+		return batches[sh]
+	}
+	for key, value := range saved {
+		batchFor(key).saved[key] = value
+	}
+	for key := range deleted {
+		batch := batchFor(key)
+		batch.deleted = append(batch.deleted, key)
+	}
+
+	changed := len(saved) > 0
+	for sh, batch := range batches {
+		var removed []string
+		sh.update(func(data v1Data) {
+			for key, value := range batch.saved {
+				data[key] = value
+				s.revisions.bump(key)
+				s.codecs.set(key, codecName)
+			}
+			for _, key := range batch.deleted {
+				if _, exists := data[key]; exists {
+					delete(data, key)
+					s.revisions.remove(key)
+					s.tags.remove(key)
+					s.codecs.remove(key)
+					removed = append(removed, key)
+				}
+			}
+		})
+		changed = changed || len(removed) > 0
+	}
+
+	for key := range saved {
+		s.access.record(key, true)
+	}
+	if len(deleted) > 0 {
+		s.aliases.removeTargets(func(target string) bool { return deleted[target] })
+	}
+
+	if changed && s.autoFlush {
+		return s.Flush()
+	}
+	return nil
+}
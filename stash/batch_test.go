@@ -0,0 +1,70 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveAllAndDeleteAll(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+
+	entries := make(map[string]interface{})
+	for i := 0; i < 500; i++ {
+		entries[fmt.Sprintf("key-%d", i)] = i
+	}
+	require.Nil(t, s.SaveAll(entries))
+
+	s, err = NewStash(filename, true)
+	require.Nil(t, err)
+	require.Equal(t, 500, s.Len())
+
+	var result int
+	require.Nil(t, s.Read("key-123", &result))
+	require.Equal(t, 123, result)
+
+	require.Nil(t, s.DeleteAll([]string{"key-1", "key-2", "missing"}))
+
+	s, err = NewStash(filename, false)
+	require.Nil(t, err)
+	require.Equal(t, 498, s.Len())
+	require.False(t, s.Has("key-1"))
+	require.True(t, s.Has("key-3"))
+}
+
+func TestSaveAllUnmarshallable(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+
+	err = s.SaveAll(map[string]interface{}{"good": 1, "bad": make(chan int)})
+	require.NotNil(t, err)
+	require.Equal(t, 0, s.Len())
+}
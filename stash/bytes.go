@@ -0,0 +1,47 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+// LoadFromBytes constructs a Stash from the contents of a Stash file, such as those
+// produced by SerializeToBytes. The Stash is not backed by a file, so it cannot be
+// flushed. Together with SerializeToBytes, it allows the file format to be fuzzed and
+// property-tested without touching the disk.
+func LoadFromBytes(data []byte) (*Stash, error) {
+	container, v1data, err := decodeFile(data)
+	if err != nil {
+		return nil, err
+	}
+
+	s := newStash("", defaultOptions())
+	s.version = dataVersion(container.Version)
+	s.loadContainer(container, v1data)
+	s.data = newShardedData(s.shardCount, v1data)
+	return s, nil
+}
+
+// SerializeToBytes returns what Flush would write to disk.
+func (s *Stash) SerializeToBytes() ([]byte, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.encodeFile()
+}
@@ -0,0 +1,60 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSerializeRoundTrip(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStashWithHeader(filename, false, Header{AppID: "app", SchemaVersion: 2})
+	require.Nil(t, err)
+	require.Nil(t, s.Save("key", []string{"a", "b"}))
+	require.Nil(t, s.Alias("alias", "key"))
+
+	data, err := s.SerializeToBytes()
+	require.Nil(t, err)
+
+	loaded, err := LoadFromBytes(data)
+	require.Nil(t, err)
+	require.Equal(t, Header{AppID: "app", SchemaVersion: 2}, loaded.Header())
+
+	var result []string
+	require.Nil(t, loaded.Read("alias", &result))
+	require.Equal(t, []string{"a", "b"}, result)
+
+	again, err := loaded.SerializeToBytes()
+	require.Nil(t, err)
+	require.Equal(t, data, again)
+}
+
+func TestLoadFromBytesInvalid(t *testing.T) {
+	_, err := LoadFromBytes([]byte("not json"))
+	require.NotNil(t, err)
+
+	_, err = LoadFromBytes([]byte(`{"Version": 99, "Data": {}}`))
+	require.IsType(t, UnknownVersionError{}, err)
+}
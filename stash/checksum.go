@@ -0,0 +1,79 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+)
+
+// CorruptFileError indicates the file looks like a Stash file, but is damaged: it is
+// truncated, or its data does not match the checksum stored with it. To edit a file by
+// hand, remove its Checksum field; a new checksum is written by the next Flush if
+// WithChecksum is used.
+type CorruptFileError struct {
+	s string
+}
+
+func (e CorruptFileError) Error() string {
+	return fmt.Sprintf("stash file is corrupt: %s", e.s)
+}
+
+// WithChecksum stores a checksum of the data in the file, which is checked whenever the
+// file is read, so that bit rot is reported as a CorruptFileError rather than loading
+// damaged data. Files with a checksum use version 2 of the file format, which releases
+// that predate checksums cannot read, so only enable this once every program sharing
+// the file supports it. Without this option, files are written in version 1 format.
+func WithChecksum() Option {
+	return func(o *options) {
+		o.checksum = true
+	}
+}
+
+// checksumTable is used to checksum the data in the file. CRC-32C is used as it is
+// fast enough to compute on every Flush, and detects truncation and bit rot.
+var checksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// dataChecksum returns the checksum stored in the file for the marshalled data.
+func dataChecksum(data []byte) string {
+	return fmt.Sprintf("crc32c:%08x", crc32.Checksum(data, checksumTable))
+}
+
+// checkChecksum returns a CorruptFileError if c has a checksum that does not match its
+// data. Files without a checksum are accepted. Only version 2 files are checked, as a
+// version 1 file may have been rewritten by an older release that kept the checksum.
+func checkChecksum(c *container) error {
+	if c.Checksum == "" {
+		return nil
+	}
+	if actual := dataChecksum(c.Data); actual != c.Checksum {
+		return CorruptFileError{fmt.Sprintf("data checksum is %s, expected %s", actual, c.Checksum)}
+	}
+	return nil
+}
+
+// looksTruncated reports whether data, which could not be decoded, appears to be the
+// start of a Stash file.
+func looksTruncated(data []byte) bool {
+	trimmed := bytes.TrimSpace(data)
+	return bytes.HasPrefix(trimmed, []byte("{")) && !bytes.HasSuffix(trimmed, []byte("}")) &&
+		bytes.Contains(trimmed, []byte(`"Version":`))
+}
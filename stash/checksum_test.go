@@ -0,0 +1,141 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecksumDetectsCorruption(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := Open(filename, WithAutoFlush(true), WithChecksum())
+	require.Nil(t, err)
+	require.Nil(t, s.Save("key", "value"))
+
+	data, err := ioutil.ReadFile(filename)
+	require.Nil(t, err)
+	require.True(t, bytes.Contains(data, []byte(`"Checksum":"crc32c:`)))
+
+	corrupted := bytes.Replace(data, []byte(`"value"`), []byte(`"valve"`), 1)
+	require.Nil(t, ioutil.WriteFile(filename, corrupted, 0600))
+
+	_, err = NewStash(filename, false)
+	require.IsType(t, CorruptFileError{}, err)
+}
+
+func TestChecksumDetectsTruncation(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := Open(filename, WithAutoFlush(true), WithChecksum())
+	require.Nil(t, err)
+	require.Nil(t, s.Save("key", "value"))
+
+	data, err := ioutil.ReadFile(filename)
+	require.Nil(t, err)
+	require.Nil(t, ioutil.WriteFile(filename, data[:len(data)/2], 0600))
+
+	_, err = NewStash(filename, false)
+	require.Equal(t, CorruptFileError{"file is truncated"}, err)
+}
+
+func TestChecksumOptional(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	// Files written before checksums were added are accepted
+	require.Nil(t, ioutil.WriteFile(filename, []byte(`{"Version":1,"Data":{"key":"value"}}`), 0600))
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+
+	var result string
+	require.Nil(t, s.Read("key", &result))
+	require.Equal(t, "value", result)
+}
+
+func TestChecksumWithHTMLCharacters(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := Open(filename, WithAutoFlush(true), WithChecksum())
+	require.Nil(t, err)
+
+	raw := []byte(`{"url":"http://x/?a=1&b=2","expr":"a<b>c"}`)
+	sum := sha256.Sum256(raw)
+	require.Nil(t, s.SavePremarshalled("key", raw, sum[:]))
+
+	s, err = NewStash(filename, false)
+	require.Nil(t, err)
+
+	var result map[string]string
+	require.Nil(t, s.Read("key", &result))
+	require.Equal(t, map[string]string{"url": "http://x/?a=1&b=2", "expr": "a<b>c"}, result)
+}
+
+func TestChecksumIgnoredInVersion1File(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	// An older release keeps an unrecognised checksum while rewriting the data
+	fileData := `{"Version":1,"Data":{"key":"changed"},"Checksum":"crc32c:00000000"}`
+	require.Nil(t, ioutil.WriteFile(filename, []byte(fileData), 0600))
+
+	s, err := Open(filename, WithAutoFlush(true), WithChecksum())
+	require.Nil(t, err)
+
+	var result string
+	require.Nil(t, s.Read("key", &result))
+	require.Equal(t, "changed", result)
+
+	// Writing the file with a checksum upgrades it to version 2, so older releases
+	// leave it alone
+	require.Nil(t, s.Save("key", "value"))
+	data, err := ioutil.ReadFile(filename)
+	require.Nil(t, err)
+	require.True(t, bytes.Contains(data, []byte(`"Version":2`)))
+
+	_, err = NewStash(filename, false)
+	require.Nil(t, err)
+}
+
+func TestVersion1FileStaysVersion1WithoutChecksum(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	require.Nil(t, ioutil.WriteFile(filename, []byte(`{"Version":1,"Data":{"key":"value"}}`), 0600))
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+	require.Nil(t, s.Save("key", "new value"))
+
+	data, err := ioutil.ReadFile(filename)
+	require.Nil(t, err)
+	require.True(t, bytes.Contains(data, []byte(`"Version":1`)))
+	require.False(t, bytes.Contains(data, []byte(`"Checksum"`)))
+}
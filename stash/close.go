@@ -0,0 +1,71 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"github.com/pkg/errors"
+	"sync/atomic"
+)
+
+// ErrClosed is returned when using a Stash after Close has been called.
+var ErrClosed = errors.New("stash is closed")
+
+// Close flushes any changes to disk, removing the write journal, stops any background
+// mirroring and marks the Stash as closed. Afterwards, methods that read, change, export
+// or flush the Stash and can return an error return ErrClosed, as does calling Close
+// again; files opened through FS fail with ErrClosed too. Methods that cannot return
+// an error, such as Has, Keys, Len and KeysByUpdated, go on reporting the contents the
+// Stash held when it was closed. Stashes opened with WithReadOnly are not flushed.
+//
+// If the final flush fails, the Stash is still closed and the error is returned.
+func (s *Stash) Close() error {
+	s.freeze.RLock()
+	defer s.freeze.RUnlock()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
+
+	var err error
+	if !s.readOnly {
+		_, err = s.flush()
+	}
+	atomic.StoreInt32(&s.closed, 1)
+
+	if s.journal != nil {
+		s.journal.Close()
+		s.journal = nil
+	}
+	if s.mirror != nil {
+		s.mirror.stop()
+		s.mirror = nil
+	}
+	return err
+}
+
+// checkOpen returns ErrClosed if Close has been called.
+func (s *Stash) checkOpen() error {
+	if atomic.LoadInt32(&s.closed) != 0 {
+		return ErrClosed
+	}
+	return nil
+}
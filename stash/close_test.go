@@ -0,0 +1,112 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"encoding/json"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClose(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+	require.Nil(t, s.Save("key", 1))
+	require.Nil(t, s.Close())
+
+	// Pending changes were flushed
+	other, err := NewStash(filename, false)
+	require.Nil(t, err)
+	var result int
+	require.Nil(t, other.Read("key", &result))
+	require.Equal(t, 1, result)
+
+	require.Equal(t, ErrClosed, s.Read("key", &result))
+	require.Equal(t, ErrClosed, s.Save("key", 2))
+	require.Equal(t, ErrClosed, s.Delete("key"))
+	require.Equal(t, ErrClosed, s.Flush())
+	require.Equal(t, ErrClosed, s.Close())
+}
+
+func TestClosedExports(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+	require.Nil(t, s.Save("key", 1))
+	require.Nil(t, s.Close())
+
+	_, err = s.CountWhere(func(string, json.RawMessage) bool { return true })
+	require.Equal(t, ErrClosed, err)
+	require.Equal(t, ErrClosed, s.Archive(ioutil.Discard))
+	require.Equal(t, ErrClosed, s.ExportTemplate(ioutil.Discard, template.Must(template.New("").Parse(""))))
+	_, err = s.SerializeToBytes()
+	require.Equal(t, ErrClosed, err)
+	_, err = s.Verify()
+	require.Equal(t, ErrClosed, err)
+	_, err = s.Tags("key")
+	require.Equal(t, ErrClosed, err)
+	_, err = fs.ReadFile(s.FS(), "key")
+	require.Equal(t, ErrClosed, err.(*fs.PathError).Err)
+	require.Equal(t, ErrClosed, s.Reload())
+
+	// Methods that cannot fail still report the contents
+	require.True(t, s.Has("key"))
+	require.Equal(t, 1, s.Len())
+}
+
+func TestCloseJournaled(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+	s.SetJournal(10)
+	require.Nil(t, s.Save("key", 1))
+
+	_, err = os.Stat(filename + ".journal")
+	require.Nil(t, err)
+
+	require.Nil(t, s.Close())
+	_, err = os.Stat(filename + ".journal")
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestCloseReadOnly(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+
+	s, err = Open(filename, WithReadOnly())
+	require.Nil(t, err)
+	require.Nil(t, s.Close())
+	require.Equal(t, ErrClosed, s.Close())
+}
@@ -0,0 +1,292 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Codec converts values to and from bytes, allowing entries to be stored in formats
+// other than JSON. See SaveWithCodec.
+type Codec interface {
+	// Name identifies the codec in the Stash file. It must not change once values have
+	// been saved with the codec.
+	Name() string
+
+	// Marshal encodes v.
+	Marshal(v interface{}) ([]byte, error)
+
+	// Unmarshal decodes data into the value pointed to by v.
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// UnknownCodecError indicates an entry was saved with a codec that has not been
+// registered.
+type UnknownCodecError struct {
+	s string
+}
+
+func (e UnknownCodecError) Error() string {
+	return fmt.Sprintf("unknown codec '%s'", e.s)
+}
+
+var (
+	// JSONCodec stores values as plain JSON. It is the default codec for Save.
+	JSONCodec Codec = jsonCodec{}
+
+	// GobCodec stores values using encoding/gob. Go-specific values that JSON cannot
+	// represent faithfully, such as maps with non-string keys and floating point NaNs
+	// and infinities, round-trip exactly, but the values cannot be read by other
+	// languages or edited by hand.
+	GobCodec Codec = gobCodec{}
+
+	// BinaryCodec stores fixed-size values, such as numbers and arrays or structs of
+	// numbers, using encoding/binary in big-endian byte order.
+	BinaryCodec Codec = binaryCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                               { return "json" }
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string { return "gob" }
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type binaryCodec struct{}
+
+func (binaryCodec) Name() string { return "binary" }
+
+func (binaryCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := binary.Write(&buf, binary.BigEndian, v)
+	return buf.Bytes(), err
+}
+
+func (binaryCodec) Unmarshal(data []byte, v interface{}) error {
+	return binary.Read(bytes.NewReader(data), binary.BigEndian, v)
+}
+
+var (
+	codecsMutex sync.RWMutex
+	codecs      = map[string]Codec{
+		JSONCodec.Name():   JSONCodec,
+		GobCodec.Name():    GobCodec,
+		BinaryCodec.Name(): BinaryCodec,
+	}
+)
+
+// RegisterCodec makes codec available for reading entries saved with it. The built-in
+// codecs are always registered. A codec with the same name as an existing one replaces
+// it.
+func RegisterCodec(codec Codec) {
+	codecsMutex.Lock()
+	defer codecsMutex.Unlock()
+	codecs[codec.Name()] = codec
+}
+
+// findCodec returns the registered codec with the given name.
+func findCodec(name string) (Codec, bool) {
+	codecsMutex.RLock()
+	defer codecsMutex.RUnlock()
+	codec, ok := codecs[name]
+	return codec, ok
+}
+
+// codecTable holds the name of the codec each key was saved with, for keys that are not
+// stored as plain JSON. Like revisions, codecs must only be changed while holding the
+// lock of the key's shard, so that they change atomically with the key's value.
+type codecTable struct {
+	mutex  sync.Mutex
+	codecs map[string]string
+	used   int32 // set atomically once any key has a codec, and never cleared
+}
+
+// inUse reports whether any key may have been saved with a codec other than JSON. While
+// it returns false, values can be read without consulting the table.
+func (c *codecTable) inUse() bool {
+	return atomic.LoadInt32(&c.used) != 0
+}
+
+// get returns the name of the codec key was saved with, or "" if it is plain JSON.
+func (c *codecTable) get(key string) string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.codecs[key]
+}
+
+// set records that key was saved with the named codec, or as plain JSON if name is "".
+func (c *codecTable) set(key, name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if name == "" {
+		delete(c.codecs, key)
+		return
+	}
+	if c.codecs == nil {
+		c.codecs = make(map[string]string)
+	}
+	atomic.StoreInt32(&c.used, 1)
+	c.codecs[key] = name
+}
+
+// remove forgets the codec of key, following its deletion.
+func (c *codecTable) remove(key string) {
+	c.set(key, "")
+}
+
+// load replaces the codecs with those read from disk.
+func (c *codecTable) load(codecs map[string]string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(codecs) > 0 {
+		atomic.StoreInt32(&c.used, 1)
+	}
+	c.codecs = codecs
+}
+
+// snapshot returns a copy of the codecs, for writing to disk.
+func (c *codecTable) snapshot() map[string]string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(c.codecs) == 0 {
+		return nil
+	}
+	codecs := make(map[string]string, len(c.codecs))
+	for key, name := range c.codecs {
+		codecs[key] = name
+	}
+	return codecs
+}
+
+// SaveWithCodec behaves like Save, but encodes value with codec. This allows compact
+// binary values to be kept alongside human-editable JSON entries in the same Stash.
+// Read decodes the value with the same codec, which must be registered with
+// RegisterCodec unless it is one of the built-in codecs.
+//
+// Values saved with JSONCodec are stored as plain JSON. Values saved with any other
+// codec are stored as a JSON string holding the encoded bytes in base64, and the codec
+// name is saved with the entry's metadata. Methods that present the stored JSON, such
+// as FS, Archive and ExportTemplate, see that string, and entries restored from it by
+// Unarchive or ImportVerified are plain JSON strings.
+func (s *Stash) SaveWithCodec(key string, value interface{}, codec Codec) error {
+	start := time.Now()
+	marshalledData, codecName, err := marshalWithCodec(value, codec)
+	if err != nil {
+		return err
+	}
+
+	err = s.saveRaw(key, marshalledData, codecName)
+	s.observe("Save", key, start, len(marshalledData))
+	return err
+}
+
+// marshalWithCodec encodes value with codec. Unless codec is JSONCodec, the result is
+// the encoded bytes as a JSON string, and the codec name is returned with it. The name
+// is "" for JSONCodec.
+func marshalWithCodec(value interface{}, codec Codec) (json.RawMessage, string, error) {
+	if codec.Name() == JSONCodec.Name() {
+		marshalledData, err := json.Marshal(value)
+		return marshalledData, "", errors.Wrap(err, "error marshalling value")
+	}
+
+	encoded, err := codec.Marshal(value)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "error encoding value with codec '%s'", codec.Name())
+	}
+
+	marshalledData, err := json.Marshal(encoded)
+	return marshalledData, codec.Name(), errors.Wrap(err, "error marshalling value")
+}
+
+// decodeValue stores the value held in item into the variable pointed to by ptr, using
+// the named codec, or as plain JSON if codecName is "".
+func decodeValue(item json.RawMessage, codecName string, ptr interface{}) error {
+	if codecName == "" {
+		return json.Unmarshal(item, ptr)
+	}
+
+	codec, ok := findCodec(codecName)
+	if !ok {
+		return UnknownCodecError{codecName}
+	}
+	var encoded []byte
+	if err := json.Unmarshal(item, &encoded); err != nil {
+		return errors.Wrapf(err, "failed to unmarshal value encoded with codec '%s'", codecName)
+	}
+	return errors.Wrapf(codec.Unmarshal(encoded, ptr), "error decoding value with codec '%s'", codecName)
+}
+
+// snapshotWithCodecs returns a frozen copy of d, along with the codecs of its keys. If
+// any codec other than JSON has been used, every shard's lock is held while both are
+// captured, so that each value is paired with the codec it was saved with.
+func (s *Stash) snapshotWithCodecs(d shardedData) (shardedData, map[string]string) {
+	frozen := d.frozen()
+	if !s.codecs.inUse() {
+		return frozen, nil
+	}
+
+	for _, sh := range d {
+		sh.mutex.Lock()
+	}
+	frozen = d.frozen()
+	codecs := s.codecs.snapshot()
+	for _, sh := range d {
+		sh.mutex.Unlock()
+	}
+	return frozen, codecs
+}
+
+// entry returns the value of key, the name of the codec it was saved with and whether
+// it exists. No lock is taken unless a codec other than JSON has been used, in which
+// case the shard's lock is held so that the value and codec are read together.
+func (s *Stash) entry(data shardedData, key string) (json.RawMessage, string, bool) {
+	sh := data.shardFor(key)
+	item, ok := sh.get()[key]
+	if !s.codecs.inUse() {
+		return item, "", ok
+	}
+
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+	item, ok = sh.get()[key]
+	return item, s.codecs.get(key), ok
+}
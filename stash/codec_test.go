@@ -0,0 +1,240 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type point struct {
+	X, Y int32
+}
+
+func TestSaveWithCodec(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+
+	require.Nil(t, s.SaveWithCodec("config", map[string]string{"name": "example"}, JSONCodec))
+	require.Nil(t, s.SaveWithCodec("names", []string{"a", "b"}, GobCodec))
+	require.Nil(t, s.SaveWithCodec("origin", point{X: 3, Y: -4}, BinaryCodec))
+
+	// Reopen to check the codec tags are persisted
+	s, err = NewStash(filename, false)
+	require.Nil(t, err)
+
+	var config map[string]string
+	require.Nil(t, s.Read("config", &config))
+	require.Equal(t, "example", config["name"])
+
+	var names []string
+	require.Nil(t, s.Read("names", &names))
+	require.Equal(t, []string{"a", "b"}, names)
+
+	var origin point
+	require.Nil(t, s.Read("origin", &origin))
+	require.Equal(t, point{X: 3, Y: -4}, origin)
+
+	// JSON values remain human-editable
+	raw, err := ioutil.ReadFile(filename)
+	require.Nil(t, err)
+	require.True(t, strings.Contains(string(raw), `"name":"example"`))
+
+	// Saving with Save replaces the tagged value
+	require.Nil(t, s.Save("origin", "here"))
+	var here string
+	require.Nil(t, s.Read("origin", &here))
+	require.Equal(t, "here", here)
+}
+
+type reverseCodec struct{}
+
+func (reverseCodec) Name() string { return "reverse" }
+
+func (reverseCodec) Marshal(v interface{}) ([]byte, error) {
+	b := []byte(v.(string))
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b, nil
+}
+
+func (c reverseCodec) Unmarshal(data []byte, v interface{}) error {
+	b, _ := c.Marshal(string(data))
+	*v.(*string) = string(b)
+	return nil
+}
+
+func TestUnknownCodec(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+
+	require.Nil(t, s.SaveWithCodec("key", "hello", reverseCodec{}))
+
+	var value string
+	err = s.Read("key", &value)
+	require.IsType(t, UnknownCodecError{}, err)
+
+	RegisterCodec(reverseCodec{})
+	require.Nil(t, s.Read("key", &value))
+	require.Equal(t, "hello", value)
+}
+
+func TestGobCodecGoTypes(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	type goValue struct {
+		Grid map[point]string
+		Max  float64
+		When time.Time
+	}
+	value := goValue{
+		Grid: map[point]string{{X: 1, Y: 2}: "a"},
+		Max:  math.Inf(1),
+		When: time.Date(2017, 6, 1, 12, 0, 0, 123456789, time.FixedZone("X", 3600)),
+	}
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+
+	// JSON cannot represent these values
+	require.NotNil(t, s.Save("value", value))
+	require.Nil(t, s.SaveWithCodec("value", value, GobCodec))
+
+	s, err = NewStash(filename, false)
+	require.Nil(t, err)
+
+	var out goValue
+	require.Nil(t, s.Read("value", &out))
+	require.Equal(t, value.Grid, out.Grid)
+	require.True(t, math.IsInf(out.Max, 1))
+	require.True(t, value.When.Equal(out.When))
+	_, offset := out.When.Zone()
+	require.Equal(t, 3600, offset)
+}
+
+func TestCodecKeptInMetadata(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+
+	// Ordinary values that look like codec-encoded ones are left alone
+	lookalike := map[string]string{"$codec": "gob", "$data": "AAAA"}
+	require.Nil(t, s.Save("lookalike", lookalike))
+	require.Nil(t, s.SaveWithCodec("names", []string{"a", "b"}, GobCodec))
+
+	s, err = NewStash(filename, false)
+	require.Nil(t, err)
+
+	var result map[string]string
+	require.Nil(t, s.Read("lookalike", &result))
+	require.Equal(t, lookalike, result)
+
+	raw, err := ioutil.ReadFile(filename)
+	require.Nil(t, err)
+	require.True(t, strings.Contains(string(raw), `"Codecs":{"names":"gob"}`))
+
+	// Overwriting or deleting an entry forgets its codec
+	require.Nil(t, s.Save("names", "plain"))
+	require.Empty(t, s.codecs.snapshot())
+	require.Nil(t, s.SaveWithCodec("names", []string{"c"}, GobCodec))
+	require.Nil(t, s.Delete("names"))
+	require.Empty(t, s.codecs.snapshot())
+}
+
+func TestCodecJournaled(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".journal")
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+	s.SetJournal(100)
+	require.Nil(t, s.SaveWithCodec("origin", point{X: 3, Y: -4}, BinaryCodec))
+
+	s, err = NewStash(filename, false)
+	require.Nil(t, err)
+
+	var origin point
+	require.Nil(t, s.Read("origin", &origin))
+	require.Equal(t, point{X: 3, Y: -4}, origin)
+}
+
+func TestCodecsFlushedWithTheirValues(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+	for i := 0; i < 2000; i++ {
+		require.Nil(t, s.Save(fmt.Sprintf("key-%d", i), i))
+	}
+
+	// Switch a key between codecs while the Stash is being serialized
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if i%2 == 0 {
+				s.SaveWithCodec("switch", []string{"gob"}, GobCodec)
+			} else {
+				s.Save("switch", []string{"json"})
+			}
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		data, err := s.SerializeToBytes()
+		require.Nil(t, err)
+		loaded, err := LoadFromBytes(data)
+		require.Nil(t, err)
+
+		var value []string
+		if loaded.Has("switch") {
+			require.Nil(t, loaded.Read("switch", &value))
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
@@ -0,0 +1,170 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"sync"
+)
+
+// Compressor compresses the data in the Stash file. Implementations wrapping other
+// algorithms, such as zstd or lz4, can be registered with RegisterCompressor without
+// this package depending on them. See WithCompressor.
+type Compressor interface {
+	// Name identifies the compressor in the Stash file. It must not change once files
+	// have been written with the compressor.
+	Name() string
+
+	// Compress returns the compressed form of data.
+	Compress(data []byte) ([]byte, error)
+
+	// Decompress reverses Compress.
+	Decompress(data []byte) ([]byte, error)
+}
+
+// UnknownCompressorError indicates a file was written with a compressor that has not
+// been registered.
+type UnknownCompressorError struct {
+	s string
+}
+
+func (e UnknownCompressorError) Error() string {
+	return fmt.Sprintf("unknown compressor '%s'", e.s)
+}
+
+// GzipCompressor compresses with gzip at the default level. See NewGzipCompressor to
+// choose a different level.
+var GzipCompressor Compressor = gzipCompressor{level: gzip.DefaultCompression}
+
+// NewGzipCompressor returns a Compressor using gzip at the given level, such as
+// gzip.BestSpeed or gzip.BestCompression. Files written at any level are read by
+// GzipCompressor.
+func NewGzipCompressor(level int) Compressor {
+	return gzipCompressor{level: level}
+}
+
+type gzipCompressor struct {
+	level int
+}
+
+func (gzipCompressor) Name() string { return "gzip" }
+
+func (c gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, c.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	return buf.Bytes(), err
+}
+
+func (gzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+var (
+	compressorsMutex sync.RWMutex
+	compressors      = map[string]Compressor{
+		GzipCompressor.Name(): GzipCompressor,
+	}
+)
+
+// RegisterCompressor makes compressor available for reading files written with it. The
+// built-in compressors are always registered. A compressor with the same name as an
+// existing one replaces it.
+func RegisterCompressor(compressor Compressor) {
+	compressorsMutex.Lock()
+	defer compressorsMutex.Unlock()
+	compressors[compressor.Name()] = compressor
+}
+
+// findCompressor returns the registered compressor with the given name.
+func findCompressor(name string) (Compressor, bool) {
+	compressorsMutex.RLock()
+	defer compressorsMutex.RUnlock()
+	compressor, ok := compressors[name]
+	return compressor, ok
+}
+
+// WithCompressor compresses the data in the file with compressor. This can greatly
+// reduce the size of files holding many similar values, at the cost of slower flushes
+// and the file no longer being readable by eye. The header and metadata remain plain
+// JSON. Compressed files are always read transparently, whether or not this option is
+// given, provided the compressor is registered with RegisterCompressor.
+func WithCompressor(compressor Compressor) Option {
+	return func(o *options) {
+		o.compressor = compressor
+	}
+}
+
+// WithGzip compresses the data in the file with gzip at the given level. It is
+// equivalent to WithCompressor(NewGzipCompressor(level)).
+func WithGzip(level int) Option {
+	return WithCompressor(NewGzipCompressor(level))
+}
+
+// compressData returns the Data field for the file, compressing jsonData if compression
+// is enabled, along with the name of the compressor used.
+func (s *Stash) compressData(jsonData []byte) (json.RawMessage, string, error) {
+	if s.compressor == nil {
+		return jsonData, "", nil
+	}
+
+	compressed, err := s.compressor.Compress(jsonData)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed to compress data")
+	}
+
+	// Store the compressed bytes as a base64 string, so the file remains valid JSON
+	encoded, err := json.Marshal(compressed)
+	return encoded, s.compressor.Name(), errors.Wrap(err, "failed to encode compressed data")
+}
+
+// decompressData returns the uncompressed JSON data held in c.
+func decompressData(c *container) (json.RawMessage, error) {
+	if c.Compression == "" {
+		return c.Data, nil
+	}
+
+	compressor, ok := findCompressor(c.Compression)
+	if !ok {
+		return nil, UnknownCompressorError{c.Compression}
+	}
+
+	var compressed []byte
+	if err := json.Unmarshal(c.Data, &compressed); err != nil {
+		return nil, errors.Wrap(err, "failed to decode compressed data")
+	}
+	data, err := compressor.Decompress(compressed)
+	return data, errors.Wrap(err, "failed to decompress data")
+}
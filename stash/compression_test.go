@@ -0,0 +1,173 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGzipRoundTrip(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := Open(filename, WithGzip(gzip.BestCompression))
+	require.Nil(t, err)
+
+	value := strings.Repeat("compressible ", 1000)
+	require.Nil(t, s.Save("key", value))
+	require.Nil(t, s.Flush())
+
+	raw, err := ioutil.ReadFile(filename)
+	require.Nil(t, err)
+	require.Contains(t, string(raw), `"Compression":"gzip"`)
+	require.True(t, len(raw) < len(value))
+
+	// Compressed files are read without the option
+	s, err = Open(filename)
+	require.Nil(t, err)
+
+	var out string
+	require.Nil(t, s.Read("key", &out))
+	require.Equal(t, value, out)
+}
+
+func TestGzipReadsUncompressedFile(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := Open(filename)
+	require.Nil(t, err)
+	require.Nil(t, s.Save("key", "value"))
+	require.Nil(t, s.Flush())
+
+	s, err = Open(filename, WithGzip(gzip.DefaultCompression))
+	require.Nil(t, err)
+
+	var out string
+	require.Nil(t, s.Read("key", &out))
+	require.Equal(t, "value", out)
+}
+
+func TestUnsupportedCompression(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := Open(filename, WithGzip(gzip.DefaultCompression))
+	require.Nil(t, err)
+	require.Nil(t, s.Save("key", "value"))
+	require.Nil(t, s.Flush())
+
+	raw, err := ioutil.ReadFile(filename)
+	require.Nil(t, err)
+	raw = []byte(strings.Replace(string(raw), `"Compression":"gzip"`, `"Compression":"lz4"`, 1))
+	require.Nil(t, ioutil.WriteFile(filename, raw, 0600))
+
+	_, err = Open(filename)
+	require.IsType(t, UnknownCompressorError{}, errors.Cause(err))
+}
+
+// reverseCompressor is a trivial Compressor for testing.
+type reverseCompressor struct{}
+
+func (reverseCompressor) Name() string { return "reverse" }
+
+func (reverseCompressor) Compress(data []byte) ([]byte, error) {
+	out := make([]byte, len(data))
+	for i, b := range data {
+		out[len(data)-1-i] = b
+	}
+	return out, nil
+}
+
+func (c reverseCompressor) Decompress(data []byte) ([]byte, error) {
+	return c.Compress(data)
+}
+
+func TestCustomCompressor(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := Open(filename, WithCompressor(reverseCompressor{}))
+	require.Nil(t, err)
+	require.Nil(t, s.Save("key", "value"))
+	require.Nil(t, s.Flush())
+
+	raw, err := ioutil.ReadFile(filename)
+	require.Nil(t, err)
+	require.Contains(t, string(raw), `"Compression":"reverse"`)
+
+	// Unregistered compressors cannot be read
+	_, err = Open(filename)
+	require.IsType(t, UnknownCompressorError{}, errors.Cause(err))
+
+	RegisterCompressor(reverseCompressor{})
+	defer func() {
+		compressorsMutex.Lock()
+		delete(compressors, "reverse")
+		compressorsMutex.Unlock()
+	}()
+
+	s, err = Open(filename)
+	require.Nil(t, err)
+	var out string
+	require.Nil(t, s.Read("key", &out))
+	require.Equal(t, "value", out)
+}
+
+func TestChangeCompressor(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	RegisterCompressor(reverseCompressor{})
+	defer func() {
+		compressorsMutex.Lock()
+		delete(compressors, "reverse")
+		compressorsMutex.Unlock()
+	}()
+
+	s, err := Open(filename, WithGzip(gzip.BestSpeed))
+	require.Nil(t, err)
+	require.Nil(t, s.Save("key", "value"))
+	require.Nil(t, s.Flush())
+
+	// A file written with one compressor is read, then rewritten, with another
+	s, err = Open(filename, WithCompressor(reverseCompressor{}))
+	require.Nil(t, err)
+	var out string
+	require.Nil(t, s.Read("key", &out))
+	require.Equal(t, "value", out)
+	require.Nil(t, s.Flush())
+
+	raw, err := ioutil.ReadFile(filename)
+	require.Nil(t, err)
+	require.Contains(t, string(raw), `"Compression":"reverse"`)
+
+	s, err = Open(filename)
+	require.Nil(t, err)
+	require.Nil(t, s.Read("key", &out))
+	require.Equal(t, "value", out)
+}
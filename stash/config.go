@@ -0,0 +1,214 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bind stores the value associated with key into the variable pointed to by ptr, on top
+// of defaults. ptr is first set to defaults (which may be nil), then any fields present in
+// the stored value overwrite the defaults. If the key does not exist, ptr is left holding
+// the defaults and no error is returned.
+//
+//	cfg := Config{Port: 8080}
+//	err = s.Bind("config", &cfg, cfg)
+func (s *Stash) Bind(key string, ptr interface{}, defaults interface{}) error {
+	if defaults != nil {
+		marshalledDefaults, err := json.Marshal(defaults)
+		if err != nil {
+			return errors.Wrap(err, "error marshalling defaults")
+		}
+		if err = json.Unmarshal(marshalledDefaults, ptr); err != nil {
+			return errors.Wrap(err, "error applying defaults")
+		}
+	}
+
+	err := s.Read(key, ptr)
+	if _, ok := err.(NoSuchKeyError); ok {
+		return nil
+	}
+	return err
+}
+
+// BindWithEnv behaves like Bind, then overlays values from environment variables, so
+// that deployments can override stored settings without editing the file.
+//
+// If ptr points at a struct, each exported field can be overridden by a variable named
+// STASH_<KEY>_<FIELD>, where FIELD is the field's JSON name. Otherwise, the whole value
+// can be overridden by STASH_<KEY>. Names are upper-cased, with any character other than
+// a letter or digit replaced by an underscore. Variables are used verbatim for string
+// values and parsed as JSON for all other types.
+func (s *Stash) BindWithEnv(key string, ptr interface{}, defaults interface{}) error {
+	if err := s.Bind(key, ptr, defaults); err != nil {
+		return err
+	}
+
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return ErrInvalidDest
+	}
+	v = v.Elem()
+
+	if v.Kind() != reflect.Struct {
+		return overlayEnv(envName(key), v)
+	}
+
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name := jsonFieldName(field)
+		if field.PkgPath != "" || name == "-" {
+			continue
+		}
+		if err := overlayEnv(envName(key, name), v.Field(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// envName returns the environment variable name used to override the value identified by parts.
+func envName(parts ...string) string {
+	name := strings.ToUpper(strings.Join(append([]string{"STASH"}, parts...), "_"))
+	return strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// jsonFieldName returns the name the json package uses for a struct field.
+func jsonFieldName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// overlayEnv sets v from the environment variable name, if it is set.
+func overlayEnv(name string, v reflect.Value) error {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+
+	if v.Kind() == reflect.String {
+		v.SetString(value)
+		return nil
+	}
+
+	if err := json.Unmarshal([]byte(value), v.Addr().Interface()); err != nil {
+		return errors.Wrapf(err, "invalid value in environment variable %s", name)
+	}
+	return nil
+}
+
+// ReloadableConfig binds a configuration value stored in a Stash, reloading the Stash
+// whenever its file changes on disk. Create one by calling NewReloadableConfig and stop
+// it with Stop when it is no longer needed.
+type ReloadableConfig struct {
+	stash    *Stash
+	key      string
+	defaults interface{}
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	mutex   sync.Mutex
+	lastErr error // guarded by mutex
+}
+
+// NewReloadableConfig returns a ReloadableConfig for the value stored under key, which
+// checks the Stash file for changes at the given interval. Changes that have not been
+// flushed to the Stash are discarded when the file is reloaded.
+func NewReloadableConfig(s *Stash, key string, defaults interface{}, interval time.Duration) *ReloadableConfig {
+	c := &ReloadableConfig{stash: s, key: key, defaults: defaults, stop: make(chan struct{})}
+	go c.watch(interval)
+	return c
+}
+
+// Get binds the current configuration value into the variable pointed to by ptr, as
+// described for Stash.Bind.
+func (c *ReloadableConfig) Get(ptr interface{}) error {
+	return c.stash.Bind(c.key, ptr, c.defaults)
+}
+
+// GetWithEnv binds the current configuration value into the variable pointed to by ptr,
+// overlaid with environment variables as described for Stash.BindWithEnv.
+func (c *ReloadableConfig) GetWithEnv(ptr interface{}) error {
+	return c.stash.BindWithEnv(c.key, ptr, c.defaults)
+}
+
+// Err returns the error from the most recent failed reload, or nil if the most recent
+// reload succeeded. After a failed reload, Get continues to return the previous value.
+func (c *ReloadableConfig) Err() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.lastErr
+}
+
+// Stop stops checking for changes.
+func (c *ReloadableConfig) Stop() {
+	c.stopOnce.Do(func() { close(c.stop) })
+}
+
+// watch polls the Stash file until stopped, reloading it when it is changed by another
+// program. Changes made by the Stash's own writes are skipped, as reloading them would
+// discard any changes made in memory since.
+func (c *ReloadableConfig) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last, _ := os.Stat(c.stash.file)
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			info, err := os.Stat(c.stash.file)
+			if err != nil || (last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size()) {
+				continue
+			}
+			last = info
+			if c.stash.wroteFile(info) {
+				continue
+			}
+
+			err = c.stash.Reload()
+			c.mutex.Lock()
+			c.lastErr = err
+			c.mutex.Unlock()
+		}
+	}
+}
+
+// wroteFile reports whether info describes the file as the Stash last wrote it.
+func (s *Stash) wroteFile(info os.FileInfo) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.written != nil && info.ModTime().Equal(s.written.ModTime()) && info.Size() == s.written.Size()
+}
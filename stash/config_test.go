@@ -0,0 +1,177 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	Host string
+	Port int
+}
+
+func TestBind(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+
+	defaults := testConfig{Host: "localhost", Port: 8080}
+
+	var cfg testConfig
+	require.Nil(t, s.Bind("config", &cfg, defaults))
+	require.Equal(t, defaults, cfg)
+
+	require.Nil(t, s.Save("config", map[string]interface{}{"Port": 9090}))
+
+	cfg = testConfig{}
+	require.Nil(t, s.Bind("config", &cfg, defaults))
+	require.Equal(t, testConfig{Host: "localhost", Port: 9090}, cfg)
+
+	cfg = testConfig{}
+	require.Nil(t, s.Bind("config", &cfg, nil))
+	require.Equal(t, testConfig{Port: 9090}, cfg)
+}
+
+func TestReload(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s1, err := NewStash(filename, true)
+	require.Nil(t, err)
+	require.Nil(t, s1.Save("foo", "bar"))
+
+	s2, err := NewStash(filename, true)
+	require.Nil(t, err)
+	require.Nil(t, s2.Save("foo", "baz"))
+
+	require.Nil(t, s1.Reload())
+
+	var foo string
+	require.Nil(t, s1.Read("foo", &foo))
+	require.Equal(t, "baz", foo)
+}
+
+func TestReloadableConfig(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+
+	c := NewReloadableConfig(s, "config", testConfig{Host: "localhost"}, time.Millisecond)
+	defer c.Stop()
+
+	var cfg testConfig
+	require.Nil(t, c.Get(&cfg))
+	require.Equal(t, testConfig{Host: "localhost"}, cfg)
+
+	// Make sure the modification time changes, even on coarse-grained filesystems
+	time.Sleep(10 * time.Millisecond)
+
+	other, err := NewStash(filename, true)
+	require.Nil(t, err)
+	require.Nil(t, other.Save("config", testConfig{Host: "example.com", Port: 443}))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for cfg.Host != "example.com" && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+		require.Nil(t, c.Get(&cfg))
+	}
+	require.Equal(t, testConfig{Host: "example.com", Port: 443}, cfg)
+	require.Nil(t, c.Err())
+}
+
+func TestReloadableConfigIgnoresOwnWrites(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+	require.Nil(t, s.Flush())
+
+	c := NewReloadableConfig(s, "config", testConfig{}, time.Millisecond)
+	defer c.Stop()
+
+	// Make sure the modification time changes, even on coarse-grained filesystems
+	time.Sleep(10 * time.Millisecond)
+	require.Nil(t, s.Save("config", testConfig{Host: "localhost"}))
+	require.Nil(t, s.Flush())
+
+	// Reloading the Stash's own write would discard this unflushed change
+	require.Nil(t, s.Save("pending", true))
+	time.Sleep(50 * time.Millisecond)
+	require.True(t, s.Has("pending"))
+	require.Nil(t, c.Err())
+}
+
+func TestReloadClosed(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+	require.Nil(t, s.Close())
+	require.Equal(t, ErrClosed, s.Reload())
+}
+
+func TestBindWithEnv(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+	require.Nil(t, s.Save("app-config", testConfig{Host: "stored", Port: 80}))
+	require.Nil(t, s.Save("level", 3))
+
+	os.Setenv("STASH_APP_CONFIG_HOST", "from-env")
+	defer os.Unsetenv("STASH_APP_CONFIG_HOST")
+
+	var cfg testConfig
+	require.Nil(t, s.BindWithEnv("app-config", &cfg, nil))
+	require.Equal(t, testConfig{Host: "from-env", Port: 80}, cfg)
+
+	os.Setenv("STASH_APP_CONFIG_PORT", "8443")
+	defer os.Unsetenv("STASH_APP_CONFIG_PORT")
+
+	require.Nil(t, s.BindWithEnv("app-config", &cfg, nil))
+	require.Equal(t, testConfig{Host: "from-env", Port: 8443}, cfg)
+
+	os.Setenv("STASH_LEVEL", "7")
+	defer os.Unsetenv("STASH_LEVEL")
+
+	var level int
+	require.Nil(t, s.BindWithEnv("level", &level, nil))
+	require.Equal(t, 7, level)
+
+	os.Setenv("STASH_LEVEL", "seven")
+	require.NotNil(t, s.BindWithEnv("level", &level, nil))
+
+	require.Equal(t, ErrInvalidDest, s.BindWithEnv("missing", level, nil))
+}
+
+func TestEnvName(t *testing.T) {
+	require.Equal(t, "STASH_MY_APP_CONFIG_PORT", envName("my-app.config", "Port"))
+}
@@ -0,0 +1,86 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"sync"
+)
+
+// defaultRegistry holds the marshalled default values registered with a Stash, and the
+// codecs they were encoded with, if not JSON.
+type defaultRegistry struct {
+	mutex  sync.RWMutex
+	values map[string]json.RawMessage
+	codecs map[string]string
+}
+
+// get returns the default value for key and the name of its codec, if one is registered.
+func (d *defaultRegistry) get(key string) (json.RawMessage, string, bool) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	value, ok := d.values[key]
+	return value, d.codecs[key], ok
+}
+
+// RegisterDefault registers a default value for key. When key does not exist, Read
+// returns the default value instead of a NoSuchKeyError. Defaults are not stored in the
+// Stash unless SeedDefaults is called. Registering a default for a key replaces any
+// previous default.
+func (s *Stash) RegisterDefault(key string, value interface{}) error {
+	marshalledData, codecName, err := s.marshal(value)
+	if err != nil {
+		return errors.Wrap(err, "error marshalling value")
+	}
+
+	s.defaults.mutex.Lock()
+	defer s.defaults.mutex.Unlock()
+	if s.defaults.values == nil {
+		s.defaults.values = make(map[string]json.RawMessage)
+		s.defaults.codecs = make(map[string]string)
+	}
+	s.defaults.values[key] = marshalledData
+	s.defaults.codecs[key] = codecName
+	return nil
+}
+
+// SeedDefaults saves the registered default value of every key that does not exist. If
+// auto-flush is enabled, the changes are persisted with a single Flush.
+func (s *Stash) SeedDefaults() error {
+	s.defaults.mutex.RLock()
+	defer s.defaults.mutex.RUnlock()
+
+	seeded := false
+	for key, value := range s.defaults.values {
+		saved, err := s.storeRawIf(s.aliases.resolve(key), value, s.defaults.codecs[key], func(exists bool) bool {
+			return !exists
+		})
+		if err != nil {
+			return err
+		}
+		seeded = seeded || saved
+	}
+
+	if seeded && s.autoFlush {
+		return s.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestRegisterDefault(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+	require.Nil(t, s.RegisterDefault("port", 8080))
+
+	var port int
+	require.Nil(t, s.Read("port", &port))
+	require.Equal(t, 8080, port)
+
+	// Defaults are not written to disk
+	data, err := ioutil.ReadFile(filename)
+	require.Nil(t, err)
+	require.NotContains(t, string(data), "8080")
+
+	require.Nil(t, s.Save("port", 9090))
+	require.Nil(t, s.Read("port", &port))
+	require.Equal(t, 9090, port)
+
+	var other int
+	_, ok := s.Read("other", &other).(NoSuchKeyError)
+	require.True(t, ok)
+
+	u := Unmarshallable(42)
+	require.NotNil(t, s.RegisterDefault("bad", u))
+}
+
+func TestSeedDefaults(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+	require.Nil(t, s.Save("host", "example.com"))
+
+	require.Nil(t, s.RegisterDefault("host", "localhost"))
+	require.Nil(t, s.RegisterDefault("port", 8080))
+	require.Nil(t, s.SeedDefaults())
+
+	s, err = NewStash(filename, false)
+	require.Nil(t, err)
+
+	var host string
+	require.Nil(t, s.Read("host", &host))
+	require.Equal(t, "example.com", host)
+
+	var port int
+	require.Nil(t, s.Read("port", &port))
+	require.Equal(t, 8080, port)
+}
@@ -0,0 +1,83 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// WithDeterministic ensures the same logical content always produces a byte-identical
+// file, regardless of the order in which it was written, so that files can be compared
+// by hash or checked in as reproducible seed data. Values are written in a canonical
+// form, with object members sorted by name, strings escaped consistently and
+// non-integer numbers written as Go formats a float64. Access times, usage statistics,
+// revisions and update times depend on history rather than content, so they are not
+// written and start afresh each time the Stash is opened.
+func WithDeterministic() Option {
+	return func(o *options) {
+		o.deterministic = true
+	}
+}
+
+// canonicalJSON returns the canonical form of the JSON value raw, as described for
+// WithDeterministic.
+func canonicalJSON(raw json.RawMessage) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(canonicalNumbers(value))
+}
+
+// canonicalNumbers rewrites every non-integer number in value, which was decoded with
+// UseNumber, in the form json.Marshal gives a float64. Integers are left as written, so
+// that those too large for a float64 keep their precision.
+func canonicalNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, member := range v {
+			v[key] = canonicalNumbers(member)
+		}
+	case []interface{}:
+		for i, element := range v {
+			v[i] = canonicalNumbers(element)
+		}
+	case json.Number:
+		if !strings.ContainsAny(string(v), ".eE") {
+			return v
+		}
+		f, err := strconv.ParseFloat(string(v), 64)
+		if err != nil {
+			return v
+		}
+		formatted, err := json.Marshal(f)
+		if err != nil {
+			return v
+		}
+		return json.Number(formatted)
+	}
+	return value
+}
@@ -0,0 +1,97 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// savePremarshalled saves raw with SavePremarshalled, computing its digest.
+func savePremarshalled(t *testing.T, s *Stash, key string, raw string) {
+	sum := sha256.Sum256([]byte(raw))
+	require.Nil(t, s.SavePremarshalled(key, []byte(raw), sum[:]))
+}
+
+func TestDeterministic(t *testing.T) {
+	filename1 := makeTempFilename()
+	defer os.Remove(filename1)
+	filename2 := makeTempFilename()
+	defer os.Remove(filename2)
+
+	s1, err := Open(filename1, WithDeterministic(), WithGzip(gzip.BestCompression))
+	require.Nil(t, err)
+	s1.SetUsageStats(true)
+	require.Nil(t, s1.Save("a", 1))
+	require.Nil(t, s1.Save("b", "temporary"))
+	require.Nil(t, s1.Delete("b"))
+	savePremarshalled(t, s1, "c", `{"y": 1.50, "x": [1e2, "A"]}`)
+	require.Nil(t, s1.SaveWithOptions("d", true, SaveOptions{Tags: []string{"t"}}))
+	var out int
+	require.Nil(t, s1.Read("a", &out))
+	require.Nil(t, s1.Flush())
+
+	s2, err := Open(filename2, WithDeterministic(), WithGzip(gzip.BestCompression))
+	require.Nil(t, err)
+	require.Nil(t, s2.SaveWithOptions("d", true, SaveOptions{Tags: []string{"t"}}))
+	savePremarshalled(t, s2, "c", `{"x":[100,"A"],"y":1.5}`)
+	require.Nil(t, s2.Save("a", 0))
+	require.Nil(t, s2.Save("a", 1))
+	require.Nil(t, s2.Flush())
+
+	data1, err := ioutil.ReadFile(filename1)
+	require.Nil(t, err)
+	data2, err := ioutil.ReadFile(filename2)
+	require.Nil(t, err)
+	require.Equal(t, string(data1), string(data2))
+
+	// The canonical values read back as saved
+	s1, err = Open(filename1)
+	require.Nil(t, err)
+	var c struct {
+		X []interface{}
+		Y float64
+	}
+	require.Nil(t, s1.Read("c", &c))
+	require.Equal(t, []interface{}{float64(100), "A"}, c.X)
+	require.Equal(t, 1.5, c.Y)
+}
+
+func TestCanonicalJSON(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{`{"b": 1, "a": {"d": 2.0, "c": 3}}`, `{"a":{"c":3,"d":2},"b":1}`},
+		{`[1.10, 2e1, 12345678901234567890]`, `[1.1,20,12345678901234567890]`},
+		{`"<é>"`, `"\u003cé\u003e"`},
+		{`"\u003c\u00e9>"`, `"\u003cé\u003e"`},
+		{`null`, `null`},
+	}
+	for _, test := range tests {
+		out, err := canonicalJSON([]byte(test.in))
+		require.Nil(t, err)
+		require.Equal(t, test.out, string(out))
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"github.com/pkg/errors"
+	"os"
+)
+
+// SetDryRun controls dry-run mode, which is useful for rehearsing migrations and for
+// testing flush behaviour. While trace is not nil, Flush encodes the Stash and checks
+// that the result can be read back, but leaves the file on disk untouched. Instead,
+// trace is called with a report of how the file would change: keys that would be added
+// are listed in MissingOnDisk, keys that would be removed in MissingInMemory, and keys
+// whose values would change in Different. trace must not call methods on the Stash.
+// Changes are not journaled in dry-run mode.
+//
+// Passing nil ends dry-run mode. Changes made while it was enabled are still held in
+// memory, and are written by the next Flush.
+func (s *Stash) SetDryRun(trace func(VerifyReport)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.dryRun = trace
+}
+
+// rehearseWrite validates the file contents produced by encodeFile and reports how they
+// differ from the file on disk to the dry-run trace. The caller must hold s.mutex.
+func (s *Stash) rehearseWrite(jsonFileData []byte) error {
+	_, wouldWrite, err := decodeFile(jsonFileData)
+	if err != nil {
+		return errors.WithMessage(err, "dry run produced an unreadable file")
+	}
+
+	_, onDisk, err := s.readFile(nil)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	s.dryRun(compareData(wouldWrite, onDisk))
+	return nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDryRun(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+	require.Nil(t, s.Save("kept", 1))
+	require.Nil(t, s.Save("changed", 1))
+	require.Nil(t, s.Save("removed", 1))
+
+	before, err := ioutil.ReadFile(filename)
+	require.Nil(t, err)
+
+	var reports []VerifyReport
+	s.SetDryRun(func(report VerifyReport) {
+		reports = append(reports, report)
+	})
+
+	require.Nil(t, s.Save("changed", 2))
+	require.Nil(t, s.Delete("removed"))
+	require.Nil(t, s.Save("added", 1))
+
+	after, err := ioutil.ReadFile(filename)
+	require.Nil(t, err)
+	require.Equal(t, before, after)
+
+	require.Len(t, reports, 3)
+	require.Equal(t, VerifyReport{
+		MissingOnDisk:   []string{"added"},
+		MissingInMemory: []string{"removed"},
+		Different:       []string{"changed"},
+	}, reports[2])
+
+	// Changes are written once dry-run mode ends
+	s.SetDryRun(nil)
+	require.Nil(t, s.Flush())
+	report, err := s.Verify()
+	require.Nil(t, err)
+	require.True(t, report.OK())
+}
+
+func TestDryRunNewFile(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+
+	var report VerifyReport
+	s.SetDryRun(func(r VerifyReport) { report = r })
+	require.Nil(t, s.Save("key", 1))
+	require.Nil(t, s.Flush())
+
+	require.Equal(t, []string{"key"}, report.MissingOnDisk)
+	_, err = os.Stat(filename)
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,100 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+)
+
+// WithDurability controls whether changes are synced to disk before Flush, or a
+// journaled change, returns. When enabled, Flush writes a temporary file alongside the
+// Stash file, syncs it, renames it over the Stash file and syncs the directory, so that
+// a crash leaves either the old or the new contents in place and a successful Flush
+// survives a power failure. Journal entries are synced as they are written. This is
+// slower, so it is disabled by default.
+func WithDurability(durable bool) Option {
+	return func(o *options) {
+		o.durable = durable
+	}
+}
+
+// replaceFile durably replaces the Stash file with data, via a temporary file. The
+// caller must hold s.mutex.
+func (s *Stash) replaceFile(data []byte) error {
+	temp := s.file + ".tmp"
+	err := s.stageFile(data)
+	if err == nil {
+		err = s.rename(temp, s.file)
+	}
+	if err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return syncDir(s.file)
+}
+
+// stageFile writes data to a temporary file alongside the Stash file, ready to be
+// renamed over it. If the Stash file exists, the temporary file is given the same
+// permissions, so that replacing the file does not change them. The caller must hold
+// s.mutex.
+func (s *Stash) stageFile(data []byte) error {
+	temp := s.file + ".tmp"
+	info, statErr := os.Stat(s.file)
+
+	// Remove any temporary file left behind by a crash, which may have other permissions
+	os.Remove(temp)
+	if err := s.writeFile(temp, data); err != nil {
+		return err
+	}
+	if statErr == nil {
+		return os.Chmod(temp, info.Mode().Perm())
+	}
+	return nil
+}
+
+// writeFile writes data to the file name, creating it with perm if necessary. If sync
+// is true, the data is synced to disk before returning.
+func writeFile(name string, data []byte, perm os.FileMode, sync bool) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil && sync {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// syncDir syncs the directory containing name, so that a file created or renamed in it
+// survives a crash.
+func syncDir(name string) error {
+	dir, err := os.Open(filepath.Dir(name))
+	if err != nil {
+		return errors.Wrap(err, "failed to open directory")
+	}
+	defer dir.Close()
+	return errors.Wrap(dir.Sync(), "failed to sync directory")
+}
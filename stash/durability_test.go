@@ -0,0 +1,92 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDurableFlush(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := Open(filename, WithAutoFlush(true), WithDurability(true))
+	require.Nil(t, err)
+	require.Nil(t, s.Save("key", 1))
+
+	_, err = os.Stat(filename + ".tmp")
+	require.True(t, os.IsNotExist(err))
+
+	other, err := NewStash(filename, false)
+	require.Nil(t, err)
+	var result int
+	require.Nil(t, other.Read("key", &result))
+	require.Equal(t, 1, result)
+}
+
+func TestDurableFlushFailureKeepsFile(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := Open(filename, WithAutoFlush(true), WithDurability(true))
+	require.Nil(t, err)
+	require.Nil(t, s.Save("key", 1))
+
+	faults := &Faults{}
+	s.SetFaultInjector(faults)
+
+	faults.ShortNextWrite(5)
+	require.Equal(t, io.ErrShortWrite, errors.Cause(s.Save("key", 2)))
+
+	faults.FailNextRename(errors.New("rename failed"))
+	require.NotNil(t, s.Save("key", 3))
+
+	// The original file is intact and no temporary file is left behind
+	_, err = os.Stat(filename + ".tmp")
+	require.True(t, os.IsNotExist(err))
+
+	other, err := NewStash(filename, false)
+	require.Nil(t, err)
+	var result int
+	require.Nil(t, other.Read("key", &result))
+	require.Equal(t, 1, result)
+}
+
+func TestDurableFlushKeepsPermissions(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := Open(filename, WithAutoFlush(true))
+	require.Nil(t, err)
+	require.Nil(t, os.Chmod(filename, 0644))
+
+	s, err = Open(filename, WithAutoFlush(true), WithDurability(true))
+	require.Nil(t, err)
+	require.Nil(t, s.Save("key", 1))
+
+	info, err := os.Stat(filename)
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0644), info.Mode().Perm())
+}
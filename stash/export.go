@@ -0,0 +1,68 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"text/template"
+)
+
+// decodedEntries returns every entry, decoded into generic values. Numbers are decoded
+// as json.Number, so they keep their precision.
+func (s *Stash) decodedEntries() (map[string]interface{}, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	data, ok := s.data.(shardedData)
+	if !ok {
+		return nil, UnknownVersionError{s.version}
+	}
+
+	result := make(map[string]interface{})
+	for key, raw := range data.merged() {
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.UseNumber()
+
+		var value interface{}
+		if err := decoder.Decode(&value); err != nil {
+			return nil, errors.Wrapf(err, "failed to decode value of key '%s'", key)
+		}
+		result[key] = value
+	}
+	return result, nil
+}
+
+// ExportTemplate executes tmpl with the contents of the Stash, writing the output to w.
+// The template's data is a map[string]interface{} from each key to its value, decoded as
+// by json.Unmarshal into an interface{}, except that numbers are json.Number values.
+// For example, the following template lists each key and value:
+//
+//	{{range $key, $value := .}}{{$key}}={{$value}}
+//	{{end}}
+func (s *Stash) ExportTemplate(w io.Writer, tmpl *template.Template) error {
+	entries, err := s.decodedEntries()
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, entries)
+}
@@ -0,0 +1,50 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+	"text/template"
+)
+
+func TestExportTemplate(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+	require.Nil(t, s.Save("port", 8080))
+	require.Nil(t, s.Save("host", "localhost"))
+	require.Nil(t, s.Save("server", struct1{Foo: "web", Bar: true}))
+
+	tmpl := template.Must(template.New("config").Parse(
+		`{{range $key, $value := .}}{{if ne $key "server"}}{{$key}}={{$value}}
+{{end}}{{end}}server={{.server.Foo}} enabled={{.server.Bar}}`))
+
+	var buf bytes.Buffer
+	require.Nil(t, s.ExportTemplate(&buf, tmpl))
+	require.Equal(t, "host=localhost\nport=8080\nserver=web enabled=true", buf.String())
+
+	bad := template.Must(template.New("bad").Parse(`{{.port.Missing}}`))
+	require.NotNil(t, s.ExportTemplate(&buf, bad))
+}
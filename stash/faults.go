@@ -0,0 +1,136 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// FaultInjector simulates storage failures, so that applications can test how they
+// recover from them. See SetFaultInjector. It is intended for use in tests only.
+type FaultInjector interface {
+	// Write is called before data is written to the file name. It returns how many bytes
+	// of data should reach the file, and the error the write should report. If the error
+	// is not nil and no bytes should be written, the file is left untouched.
+	Write(name string, data []byte) (int, error)
+
+	// Rename is called before the file oldname replaces newname. If it returns an error,
+	// the rename is not made and the error is reported.
+	Rename(oldname, newname string) error
+}
+
+// SetFaultInjector arranges for the Stash to consult f before writing or renaming
+// files, so that failures can be injected. Passing nil removes the injector. This is
+// intended for use in tests only.
+func (s *Stash) SetFaultInjector(f FaultInjector) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.faults = f
+}
+
+// writeFile writes data to the file name, subject to any injected faults. If durability
+// is enabled, the data is synced to disk before returning. The caller must hold s.mutex.
+func (s *Stash) writeFile(name string, data []byte) error {
+	if s.faults == nil {
+		return writeFile(name, data, s.fileMode, s.durable)
+	}
+
+	n, err := s.faults.Write(name, data)
+	if n > len(data) {
+		n = len(data)
+	}
+	if err != nil && n <= 0 {
+		return err
+	}
+	if writeErr := writeFile(name, data[:n], s.fileMode, s.durable); writeErr != nil {
+		return writeErr
+	}
+	return err
+}
+
+// rename replaces newname with oldname, subject to any injected faults. The caller must
+// hold s.mutex.
+func (s *Stash) rename(oldname, newname string) error {
+	if s.faults != nil {
+		if err := s.faults.Rename(oldname, newname); err != nil {
+			return err
+		}
+	}
+	return os.Rename(oldname, newname)
+}
+
+// Faults is a FaultInjector that fails selected operations once each. The zero value
+// injects no faults.
+type Faults struct {
+	mutex      sync.Mutex
+	writeErr   error
+	shortWrite int
+	renameErr  error
+}
+
+// FailNextWrite makes the next write, such as the one made by Flush, fail with err
+// without changing the file.
+func (f *Faults) FailNextWrite(err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.writeErr = err
+}
+
+// ShortNextWrite makes the next write stop after n bytes, leaving a truncated file, and
+// fail with io.ErrShortWrite.
+func (f *Faults) ShortNextWrite(n int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.writeErr = io.ErrShortWrite
+	f.shortWrite = n
+}
+
+// FailNextRename makes the next rename, such as those made by Group.Flush or by Flush
+// with durability enabled, fail with err.
+func (f *Faults) FailNextRename(err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.renameErr = err
+}
+
+// Write implements FaultInjector.
+func (f *Faults) Write(name string, data []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.writeErr == nil {
+		return len(data), nil
+	}
+	n, err := f.shortWrite, f.writeErr
+	f.writeErr, f.shortWrite = nil, 0
+	return n, err
+}
+
+// Rename implements FaultInjector.
+func (f *Faults) Rename(oldname, newname string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	err := f.renameErr
+	f.renameErr = nil
+	return err
+}
@@ -0,0 +1,97 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFaultsFailNextWrite(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+	require.Nil(t, s.Save("key", 1))
+
+	faults := &Faults{}
+	s.SetFaultInjector(faults)
+
+	injected := errors.New("disk full")
+	faults.FailNextWrite(injected)
+	require.Equal(t, injected, errors.Cause(s.Save("key", 2)))
+
+	// The file is unchanged, and the next write succeeds
+	other, err := NewStash(filename, false)
+	require.Nil(t, err)
+	var result int
+	require.Nil(t, other.Read("key", &result))
+	require.Equal(t, 1, result)
+
+	require.Nil(t, s.Save("key", 3))
+}
+
+func TestFaultsShortNextWrite(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+
+	faults := &Faults{}
+	s.SetFaultInjector(faults)
+	faults.ShortNextWrite(5)
+	require.Equal(t, io.ErrShortWrite, errors.Cause(s.Save("key", 1)))
+
+	data, err := ioutil.ReadFile(filename)
+	require.Nil(t, err)
+	require.Len(t, data, 5)
+
+	_, err = NewStash(filename, false)
+	require.NotNil(t, err)
+}
+
+func TestFaultsFailNextRename(t *testing.T) {
+	file1 := makeTempFilename()
+	defer os.Remove(file1)
+	file2 := makeTempFilename()
+	defer os.Remove(file2)
+
+	s1, err := NewStash(file1, false)
+	require.Nil(t, err)
+	s2, err := NewStash(file2, false)
+	require.Nil(t, err)
+	require.Nil(t, s1.Save("key", 1))
+	require.Nil(t, s2.Save("key", 2))
+
+	faults := &Faults{}
+	s1.SetFaultInjector(faults)
+	s2.SetFaultInjector(faults)
+	faults.FailNextRename(errors.New("rename failed"))
+
+	require.NotNil(t, NewGroup(s1, s2).Flush())
+	require.Nil(t, NewGroup(s1, s2).Flush())
+}
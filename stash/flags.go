@@ -0,0 +1,99 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"hash/fnv"
+)
+
+// Flag is the stored state of a feature flag.
+type Flag struct {
+	Enabled    bool // enabled for everyone
+	Percentage int  // percentage of subjects the flag is enabled for, when not enabled for everyone
+}
+
+// Flags manages feature flags persisted in a Stash. Each flag is stored as a Flag, under
+// a key made of a prefix followed by the flag name. Flags that have never been set are
+// disabled.
+type Flags struct {
+	stash  *Stash
+	prefix string
+}
+
+// NewFlags returns a Flags that stores flags in s, with keys starting with prefix.
+func NewFlags(s *Stash, prefix string) *Flags {
+	return &Flags{stash: s, prefix: prefix}
+}
+
+// IsEnabled reports whether the named flag is enabled for everyone. Flags that cannot be
+// read are treated as disabled.
+func (f *Flags) IsEnabled(name string) bool {
+	flag, err := f.Get(name)
+	return err == nil && flag.Enabled
+}
+
+// IsEnabledFor reports whether the named flag is enabled for the subject, such as a user
+// ID. When the flag is being rolled out to a percentage of subjects, the same subject
+// always gets the same answer for a given flag and percentage, and increasing the
+// percentage never disables the flag for a subject. Flags that cannot be read are treated
+// as disabled.
+func (f *Flags) IsEnabledFor(name string, subject string) bool {
+	flag, err := f.Get(name)
+	if err != nil {
+		return false
+	}
+	return flag.Enabled || rolloutBucket(name, subject) < flag.Percentage
+}
+
+// Get returns the state of the named flag. A flag that has never been set is returned as
+// the zero Flag.
+func (f *Flags) Get(name string) (Flag, error) {
+	var flag Flag
+	err := f.stash.Read(f.prefix+name, &flag)
+	if _, ok := err.(NoSuchKeyError); ok {
+		return Flag{}, nil
+	}
+	return flag, err
+}
+
+// Enable enables the named flag for everyone.
+func (f *Flags) Enable(name string) error {
+	return f.stash.Save(f.prefix+name, Flag{Enabled: true})
+}
+
+// Disable disables the named flag for everyone.
+func (f *Flags) Disable(name string) error {
+	return f.stash.Save(f.prefix+name, Flag{})
+}
+
+// SetPercentage enables the named flag for the given percentage of subjects, as
+// reported by IsEnabledFor.
+func (f *Flags) SetPercentage(name string, percentage int) error {
+	return f.stash.Save(f.prefix+name, Flag{Percentage: percentage})
+}
+
+// rolloutBucket assigns the subject a stable bucket between 0 and 99 for the named flag.
+func rolloutBucket(name string, subject string) int {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write([]byte(subject))
+	return int(h.Sum32() % 100)
+}
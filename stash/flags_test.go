@@ -0,0 +1,90 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+)
+
+func TestFlags(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+
+	flags := NewFlags(s, "flags/")
+	require.False(t, flags.IsEnabled("new-ui"))
+	require.False(t, flags.IsEnabledFor("new-ui", "user1"))
+
+	require.Nil(t, flags.Enable("new-ui"))
+	require.True(t, flags.IsEnabled("new-ui"))
+	require.True(t, flags.IsEnabledFor("new-ui", "user1"))
+
+	var flag Flag
+	require.Nil(t, s.Read("flags/new-ui", &flag))
+	require.Equal(t, Flag{Enabled: true}, flag)
+
+	require.Nil(t, flags.Disable("new-ui"))
+	require.False(t, flags.IsEnabled("new-ui"))
+}
+
+func TestFlagsPercentage(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+
+	flags := NewFlags(s, "")
+	require.Nil(t, flags.SetPercentage("beta", 30))
+	require.False(t, flags.IsEnabled("beta"))
+
+	enabled := map[string]bool{}
+	for i := 0; i < 1000; i++ {
+		subject := fmt.Sprintf("user%d", i)
+		if flags.IsEnabledFor("beta", subject) {
+			enabled[subject] = true
+		}
+	}
+	require.True(t, len(enabled) > 200 && len(enabled) < 400)
+
+	// Subjects keep the flag as the rollout grows
+	require.Nil(t, flags.SetPercentage("beta", 60))
+	for subject := range enabled {
+		require.True(t, flags.IsEnabledFor("beta", subject))
+	}
+}
+
+func TestFlagsUnreadable(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+	require.Nil(t, s.Save("broken", "not a flag"))
+
+	flags := NewFlags(s, "")
+	require.False(t, flags.IsEnabled("broken"))
+	require.False(t, flags.IsEnabledFor("broken", "user1"))
+}
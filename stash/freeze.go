@@ -0,0 +1,37 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+// Freeze prevents the Stash from writing to disk until Thaw is called, so that the file
+// (and journal, if any) can be copied by an external backup tool as a consistent
+// snapshot. Freeze waits for any write in progress to finish.
+//
+// While frozen, changes are still applied in memory, but calls that write to disk,
+// including Flush and any Save with auto-flush enabled, block until Thaw is called.
+// Every call to Freeze must be followed by exactly one call to Thaw, and Freeze must not
+// be called again before then.
+func (s *Stash) Freeze() {
+	s.freeze.Lock()
+}
+
+// Thaw allows a Stash frozen by Freeze to write to disk again, releasing any blocked calls.
+func (s *Stash) Thaw() {
+	s.freeze.Unlock()
+}
@@ -0,0 +1,67 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFreeze(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+	require.Nil(t, s.Save("key", "before"))
+
+	before, err := ioutil.ReadFile(filename)
+	require.Nil(t, err)
+
+	s.Freeze()
+
+	done := make(chan error)
+	go func() {
+		done <- s.Save("key", "after")
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("Save completed while frozen")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	during, err := ioutil.ReadFile(filename)
+	require.Nil(t, err)
+	require.Equal(t, before, during)
+
+	// Other methods are not blocked
+	require.Equal(t, Header{}, s.Header())
+
+	s.Thaw()
+	require.Nil(t, <-done)
+
+	after, err := ioutil.ReadFile(filename)
+	require.Nil(t, err)
+	require.Contains(t, string(after), "after")
+}
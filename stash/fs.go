@@ -0,0 +1,202 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+//go:build go1.16
+// +build go1.16
+
+package stash
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FS returns a read-only view of the Stash as a file system, so its contents can be used
+// with code that expects one, such as template.ParseFS or http.FS. Each key is a file
+// whose contents are the stored JSON value. Keys containing slashes appear in
+// directories; for instance, the key "pages/index" is the file "index" in the directory
+// "pages". Keys that are not valid file system paths are not listed in any directory.
+//
+// Files reflect the contents of the Stash at the time they are opened.
+func (s *Stash) FS() fs.FS {
+	return stashFS{s}
+}
+
+// stashFS implements fs.FS, fs.ReadFileFS and fs.ReadDirFS over a Stash.
+type stashFS struct {
+	s *Stash
+}
+
+func (f stashFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if err := f.s.checkOpen(); err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	data, ok := f.s.data.(shardedData)
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: UnknownVersionError{f.s.version}}
+	}
+	if name != "." {
+		key := f.s.aliases.resolve(name)
+		if value, ok := data.shardFor(key).get()[key]; ok {
+			info := fileInfo{name: pathBase(name), size: int64(len(value))}
+			return &stashFile{info: info, Reader: bytes.NewReader(value)}, nil
+		}
+	}
+
+	entries := dirEntries(data.merged(), name)
+	if name != "." && len(entries) == 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &stashDir{info: fileInfo{name: pathBase(name), dir: true}, entries: entries}, nil
+}
+
+func (f stashFS) ReadFile(name string) ([]byte, error) {
+	file, err := f.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	sf, ok := file.(*stashFile)
+	if !ok {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+	return io.ReadAll(sf)
+}
+
+func (f stashFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	file, err := f.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	dir, ok := file.(*stashDir)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	return dir.ReadDir(-1)
+}
+
+// dirEntries returns the sorted entries of the directory name, given the keys in data.
+// A key that is also a directory is listed only as a file.
+func dirEntries(data v1Data, name string) []fs.DirEntry {
+	prefix := ""
+	if name != "." {
+		prefix = name + "/"
+	}
+
+	found := make(map[string]fileInfo)
+	for key, value := range data {
+		if !strings.HasPrefix(key, prefix) || !fs.ValidPath(key) || key == "." {
+			continue
+		}
+		rest := key[len(prefix):]
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			if _, exists := found[rest[:i]]; !exists {
+				found[rest[:i]] = fileInfo{name: rest[:i], dir: true}
+			}
+		} else {
+			found[rest] = fileInfo{name: rest, size: int64(len(value))}
+		}
+	}
+
+	entries := make([]fs.DirEntry, 0, len(found))
+	for _, info := range found {
+		entries = append(entries, info)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries
+}
+
+// pathBase returns the last element of a valid fs.FS path.
+func pathBase(name string) string {
+	return name[strings.LastIndexByte(name, '/')+1:]
+}
+
+// fileInfo describes a key or directory, implementing fs.FileInfo and fs.DirEntry.
+type fileInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func (i fileInfo) Name() string               { return i.name }
+func (i fileInfo) Size() int64                { return i.size }
+func (i fileInfo) ModTime() time.Time         { return time.Time{} }
+func (i fileInfo) IsDir() bool                { return i.dir }
+func (i fileInfo) Sys() interface{}           { return nil }
+func (i fileInfo) Type() fs.FileMode          { return i.Mode().Type() }
+func (i fileInfo) Info() (fs.FileInfo, error) { return i, nil }
+
+func (i fileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+// stashFile is an open key.
+type stashFile struct {
+	*bytes.Reader
+	info fileInfo
+}
+
+func (f *stashFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *stashFile) Close() error               { return nil }
+
+// stashDir is an open directory.
+type stashDir struct {
+	info    fileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *stashDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *stashDir) Close() error               { return nil }
+
+func (d *stashDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+func (d *stashDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	remaining := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(remaining) {
+		n = len(remaining)
+	}
+	d.offset += n
+	return remaining[:n], nil
+}
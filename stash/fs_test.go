@@ -0,0 +1,62 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+//go:build go1.16
+// +build go1.16
+
+package stash
+
+import (
+	"io/fs"
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFS(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+
+	require.Nil(t, s.Save("greeting", "hello"))
+	require.Nil(t, s.Save("pages/index", map[string]int{"views": 3}))
+	require.Nil(t, s.Save("pages/about/team", []string{"a", "b"}))
+	require.Nil(t, s.Save("/invalid", true))
+
+	fsys := s.FS()
+	require.Nil(t, fstest.TestFS(fsys, "greeting", "pages/index", "pages/about/team"))
+
+	data, err := fs.ReadFile(fsys, "pages/index")
+	require.Nil(t, err)
+	require.Equal(t, `{"views":3}`, string(data))
+
+	entries, err := fs.ReadDir(fsys, "pages")
+	require.Nil(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, "about", entries[0].Name())
+	require.True(t, entries[0].IsDir())
+	require.Equal(t, "index", entries[1].Name())
+
+	_, err = fs.ReadFile(fsys, "missing")
+	require.True(t, os.IsNotExist(err))
+}
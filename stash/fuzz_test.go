@@ -0,0 +1,57 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+//go:build go1.18
+// +build go1.18
+
+package stash
+
+import (
+	"bytes"
+	"testing"
+)
+
+func FuzzLoadFromBytes(f *testing.F) {
+	f.Add([]byte(`{"Version":1,"Data":{}}`))
+	f.Add([]byte(`{"Version":1,"AppID":"app","Data":{"key":"value"},"Aliases":{"alias":"key"}}`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		s, err := LoadFromBytes(data)
+		if err != nil {
+			return
+		}
+
+		// Anything that loads must serialize, and serializing must be stable
+		first, err := s.SerializeToBytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		reloaded, err := LoadFromBytes(first)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := reloaded.SerializeToBytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(first, second) {
+			t.Fatalf("serialization not stable:\n%s\n%s", first, second)
+		}
+	})
+}
@@ -0,0 +1,117 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"os"
+	"sort"
+)
+
+// Group flushes several Stashes together, so that applications splitting their data
+// across files do not end up with only some of the files updated. Create a Group by
+// calling NewGroup.
+type Group struct {
+	stashes []*Stash
+}
+
+// NewGroup returns a Group containing the given Stashes.
+func NewGroup(stashes ...*Stash) *Group {
+	unique := make([]*Stash, 0, len(stashes))
+	seen := make(map[*Stash]bool)
+	for _, s := range stashes {
+		if !seen[s] {
+			seen[s] = true
+			unique = append(unique, s)
+		}
+	}
+
+	// Always lock in the same order, so that overlapping Groups cannot deadlock
+	sort.Slice(unique, func(i, j int) bool {
+		return unique[i].file < unique[j].file
+	})
+	return &Group{stashes: unique}
+}
+
+// Flush writes every Stash in the group to disk. The new contents of every file are
+// first written alongside the original files; only if all of these writes succeed are
+// the originals replaced. If any write fails, the staged files are removed and every
+// file is left as it was. Changes held in memory are kept either way.
+//
+// Replacing the originals uses one rename per file. Renames do not normally fail once
+// the files have been written, but if one does, the returned error says which files
+// were updated.
+func (g *Group) Flush() error {
+	for _, s := range g.stashes {
+		s.freeze.RLock()
+		s.mutex.Lock()
+		defer s.freeze.RUnlock()
+		defer s.mutex.Unlock()
+	}
+
+	staged := make([][]byte, len(g.stashes))
+	var err error
+	for i, s := range g.stashes {
+		if err = s.checkWritable(); err == nil {
+			staged[i], err = s.encodeFile()
+		}
+		if err == nil {
+			if s.dryRun != nil {
+				err = s.rehearseWrite(staged[i])
+			} else {
+				err = s.stageFile(staged[i])
+			}
+		}
+		if err != nil {
+			err = errors.WithMessage(err, fmt.Sprintf("failed to stage '%s'", s.file))
+			break
+		}
+	}
+
+	if err != nil {
+		for _, s := range g.stashes {
+			os.Remove(s.file + ".tmp")
+		}
+		return err
+	}
+
+	var updated []string
+	for i, s := range g.stashes {
+		if s.dryRun != nil {
+			continue
+		}
+		err = s.rename(s.file+".tmp", s.file)
+		if err != nil {
+			return errors.Wrapf(err, "failed to replace '%s' after updating %v", s.file, updated)
+		}
+		updated = append(updated, s.file)
+		if s.durable {
+			if err = syncDir(s.file); err != nil {
+				return err
+			}
+		}
+
+		if err = s.finishWrite(staged[i], nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
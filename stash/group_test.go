@@ -0,0 +1,97 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestGroupFlush(t *testing.T) {
+	filename1 := makeTempFilename()
+	defer os.Remove(filename1)
+	filename2 := makeTempFilename()
+	defer os.Remove(filename2)
+
+	s1, err := NewStash(filename1, false)
+	require.Nil(t, err)
+	s2, err := NewStash(filename2, false)
+	require.Nil(t, err)
+
+	require.Nil(t, s1.Save("account", 100))
+	require.Nil(t, s2.Save("ledger", "credit 100"))
+
+	require.Nil(t, NewGroup(s1, s2, s1).Flush())
+
+	s1, err = NewStash(filename1, false)
+	require.Nil(t, err)
+	s2, err = NewStash(filename2, false)
+	require.Nil(t, err)
+
+	var account int
+	require.Nil(t, s1.Read("account", &account))
+	require.Equal(t, 100, account)
+
+	var ledger string
+	require.Nil(t, s2.Read("ledger", &ledger))
+	require.Equal(t, "credit 100", ledger)
+}
+
+func TestGroupFlushFailureLeavesFilesUnchanged(t *testing.T) {
+	filename1 := makeTempFilename()
+	defer os.Remove(filename1)
+
+	s1, err := NewStash(filename1, true)
+	require.Nil(t, err)
+	s2, err := NewStash(makeTempFilename()+"/not/a/directory", false)
+	require.Nil(t, err)
+
+	before, err := ioutil.ReadFile(filename1)
+	require.Nil(t, err)
+
+	s1.autoFlush = false
+	require.Nil(t, s1.Save("account", 100))
+	require.NotNil(t, NewGroup(s1, s2).Flush())
+
+	after, err := ioutil.ReadFile(filename1)
+	require.Nil(t, err)
+	require.Equal(t, before, after)
+
+	_, err = os.Stat(filename1 + ".tmp")
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestGroupFlushKeepsPermissions(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+	require.Nil(t, os.Chmod(filename, 0644))
+
+	require.Nil(t, s.Save("key", 1))
+	require.Nil(t, NewGroup(s).Flush())
+
+	info, err := os.Stat(filename)
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0644), info.Mode().Perm())
+}
@@ -0,0 +1,119 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ImportDocument reads an arbitrary JSON document from r and saves selected parts of it.
+// keyFn is called for the document itself and then for the members of each object and
+// elements of each array, with the node's location as a JSON Pointer (RFC 6901) such as
+// "/servers/0/name", and the node's value. If keyFn returns true, the value is saved under
+// the returned key and the node's children are not visited. Members are visited in
+// sorted order.
+//
+// If auto-flush is enabled, the imported values are persisted with a single Flush. If an
+// error occurs, values imported before the error are kept.
+func (s *Stash) ImportDocument(r io.Reader, keyFn func(path string, value json.RawMessage) (string, bool)) error {
+	var document json.RawMessage
+	if err := json.NewDecoder(r).Decode(&document); err != nil {
+		return errors.Wrap(err, "failed to decode document")
+	}
+
+	imported := false
+	err := walkDocument("", document, func(path string, value json.RawMessage) (bool, error) {
+		key, ok := keyFn(path, value)
+		if !ok {
+			return false, nil
+		}
+
+		var compacted bytes.Buffer
+		if err := json.Compact(&compacted, value); err != nil {
+			return true, err
+		}
+		_, err := s.storeRawIf(s.aliases.resolve(key), compacted.Bytes(), "", func(bool) bool { return true })
+		imported = imported || err == nil
+		return true, err
+	})
+
+	if imported && s.autoFlush {
+		if flushErr := s.Flush(); err == nil {
+			err = flushErr
+		}
+	}
+	return err
+}
+
+// walkDocument calls fn for value and, unless fn returns true, for each of its children.
+func walkDocument(path string, value json.RawMessage, fn func(path string, value json.RawMessage) (bool, error)) error {
+	selected, err := fn(path, value)
+	if err != nil || selected {
+		return err
+	}
+
+	switch firstByte(value) {
+	case '{':
+		var members map[string]json.RawMessage
+		if err := json.Unmarshal(value, &members); err != nil {
+			return err
+		}
+
+		names := make([]string, 0, len(members))
+		for name := range members {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		escaper := strings.NewReplacer("~", "~0", "/", "~1")
+		for _, name := range names {
+			if err := walkDocument(path+"/"+escaper.Replace(name), members[name], fn); err != nil {
+				return err
+			}
+		}
+	case '[':
+		var elements []json.RawMessage
+		if err := json.Unmarshal(value, &elements); err != nil {
+			return err
+		}
+
+		for i, element := range elements {
+			if err := walkDocument(path+"/"+strconv.Itoa(i), element, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// firstByte returns the first non-whitespace byte of value, or zero if there is none.
+func firstByte(value json.RawMessage) byte {
+	trimmed := bytes.TrimLeft(value, " \t\r\n")
+	if len(trimmed) == 0 {
+		return 0
+	}
+	return trimmed[0]
+}
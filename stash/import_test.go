@@ -0,0 +1,84 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"os"
+	"strings"
+	"testing"
+)
+
+const testDocument = `{
+	"name": "example",
+	"servers": [
+		{"host": "a.example.com", "port": 80},
+		{"host": "b.example.com", "port": 8080}
+	],
+	"a/b": {"c~d": true}
+}`
+
+func TestImportDocument(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+
+	var visited []string
+	err = s.ImportDocument(strings.NewReader(testDocument), func(path string, value json.RawMessage) (string, bool) {
+		visited = append(visited, path)
+		if strings.HasPrefix(path, "/servers/") {
+			return "server" + strings.TrimPrefix(path, "/servers/"), true
+		}
+		return path, path == "/name" || path == "/a~1b/c~0d"
+	})
+	require.Nil(t, err)
+	require.Equal(t, []string{"", "/a~1b", "/a~1b/c~0d", "/name", "/servers", "/servers/0", "/servers/1"}, visited)
+
+	s, err = NewStash(filename, false)
+	require.Nil(t, err)
+
+	var name string
+	require.Nil(t, s.Read("/name", &name))
+	require.Equal(t, "example", name)
+
+	var flag bool
+	require.Nil(t, s.Read("/a~1b/c~0d", &flag))
+	require.True(t, flag)
+
+	var server map[string]interface{}
+	require.Nil(t, s.Read("server1", &server))
+	require.Equal(t, map[string]interface{}{"host": "b.example.com", "port": 8080.0}, server)
+}
+
+func TestImportDocumentBadJSON(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+
+	err = s.ImportDocument(strings.NewReader(`{"a":`), func(path string, value json.RawMessage) (string, bool) {
+		return path, true
+	})
+	require.NotNil(t, err)
+}
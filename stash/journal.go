@@ -0,0 +1,203 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"time"
+)
+
+// journalEntry records a single change in the journal.
+type journalEntry struct {
+	Key      string
+	Value    json.RawMessage `json:",omitempty"`
+	Deleted  bool            `json:",omitempty"`
+	Revision uint64          `json:",omitempty"`
+	Updated  *time.Time      `json:",omitempty"`
+	Codec    string          `json:",omitempty"`
+}
+
+// journalFilename returns the name of the journal file that accompanies the Stash file.
+func (s *Stash) journalFilename() string {
+	return s.file + ".journal"
+}
+
+// SetJournal controls write journaling, which reduces the cost of auto-flushing. When
+// enabled, each change that would otherwise trigger a Flush is instead appended to a
+// journal file alongside the Stash file, rather than rewriting the whole file. Changes
+// are still applied in memory by copying the key's shard, so their cost grows with the
+// size of the shard, but not with the size of the file. Once compactAfter changes have
+// been journaled, the next change flushes the whole store and the journal is removed.
+// Calling Flush also removes the journal.
+//
+// Any journal left behind, for instance by a crash, is replayed when the Stash is next
+// opened. A compactAfter of zero or less disables journaling, which is the default.
+func (s *Stash) SetJournal(compactAfter int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.journalLimit = compactAfter
+}
+
+// fileExists reports whether the Stash file has been written.
+func (s *Stash) fileExists() bool {
+	_, err := os.Stat(s.file)
+	return err == nil
+}
+
+// flushChange persists the current state of key, following a change made with
+// auto-flush enabled. The change is journaled if possible, otherwise the whole store
+// is flushed. A journal is only replayed alongside an existing Stash file, so the
+// store is flushed in full if the file has not been written yet.
+func (s *Stash) flushChange(key string) error {
+	s.freeze.RLock()
+	s.mutex.Lock()
+	if s.journalLimit <= 0 || s.journalEntries >= s.journalLimit || s.dryRun != nil || s.journal == nil && !s.fileExists() {
+		s.mutex.Unlock()
+		s.freeze.RUnlock()
+		return s.Flush()
+	}
+	defer s.freeze.RUnlock()
+	defer s.mutex.Unlock()
+
+	// Journal the value now in memory, rather than the value passed to Save, so that
+	// concurrent changes to the same key are journaled in the order they are applied.
+	entry := journalEntry{Key: key}
+	sh := s.data.(shardedData).shardFor(key)
+	sh.mutex.Lock()
+	if value, ok := sh.get()[key]; ok {
+		entry.Value = value
+		entry.Revision = s.revisions.get(key)
+		entry.Codec = s.codecs.get(key)
+		if updated := s.revisions.getUpdated(key); !updated.IsZero() {
+			entry.Updated = &updated
+		}
+	} else {
+		entry.Deleted = true
+	}
+	sh.mutex.Unlock()
+
+	if s.journal == nil {
+		journal, err := os.OpenFile(s.journalFilename(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, s.fileMode)
+		if err != nil {
+			return errors.Wrap(err, "failed to open journal")
+		}
+		s.journal = journal
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal journal entry")
+	}
+	if _, err = s.journal.Write(append(line, '\n')); err != nil {
+		return errors.Wrap(err, "failed to write journal")
+	}
+	if s.durable {
+		if err = s.journal.Sync(); err != nil {
+			return errors.Wrap(err, "failed to sync journal")
+		}
+	}
+	s.journalEntries++
+	return nil
+}
+
+// resetJournal closes and removes the journal, after its changes have been flushed. The
+// caller must hold s.mutex.
+func (s *Stash) resetJournal() error {
+	if s.journal != nil {
+		s.journal.Close()
+		s.journal = nil
+	}
+	s.journalEntries = 0
+
+	if err := os.Remove(s.journalFilename()); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to remove journal")
+	}
+	return nil
+}
+
+// replayJournal applies the changes recorded in the journal, if there is one, to data
+// and to the metadata in c. Aliases referring to a deleted key are removed, as Delete
+// does.
+// A partially written final entry, left by a crash, is ignored. If recovery is not nil,
+// a description of the replay is appended to it.
+func (s *Stash) replayJournal(data v1Data, c *container, recovery *[]string) error {
+	journal, err := os.Open(s.journalFilename())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "failed to open journal")
+	}
+	defer journal.Close()
+
+	if c.Revisions == nil {
+		c.Revisions = make(map[string]uint64)
+	}
+	if c.Updated == nil {
+		c.Updated = make(map[string]time.Time)
+	}
+
+	decoder := json.NewDecoder(journal)
+	for replayed := 0; ; replayed++ {
+		var entry journalEntry
+		if err = decoder.Decode(&entry); err != nil {
+			if recovery != nil {
+				*recovery = append(*recovery, fmt.Sprintf("replayed %d journal entries", replayed))
+				if err != io.EOF {
+					*recovery = append(*recovery, "ignored incomplete journal entry")
+				}
+			}
+			return nil
+		}
+
+		if entry.Deleted {
+			delete(data, entry.Key)
+			delete(c.Revisions, entry.Key)
+			delete(c.Updated, entry.Key)
+			delete(c.Tags, entry.Key)
+			delete(c.Codecs, entry.Key)
+			for alias, target := range c.Aliases {
+				if target == entry.Key {
+					delete(c.Aliases, alias)
+				}
+			}
+		} else {
+			data[entry.Key] = entry.Value
+			c.Revisions[entry.Key] = entry.Revision
+			if entry.Revision > c.LastRevision {
+				c.LastRevision = entry.Revision
+			}
+			if entry.Updated != nil {
+				c.Updated[entry.Key] = *entry.Updated
+			}
+			if entry.Codec == "" {
+				delete(c.Codecs, entry.Key)
+			} else {
+				if c.Codecs == nil {
+					c.Codecs = make(map[string]string)
+				}
+				c.Codecs[entry.Key] = entry.Codec
+			}
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestJournalReplay(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".journal")
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+	s.SetJournal(100)
+
+	require.Nil(t, s.Save("foo", "bar"))
+	require.Nil(t, s.Save("foo", "baz"))
+	require.Nil(t, s.Save("num", 42))
+
+	_, err = os.Stat(filename + ".journal")
+	require.Nil(t, err)
+
+	// Simulate a crash part way through writing an entry
+	journal, err := os.OpenFile(filename+".journal", os.O_WRONLY|os.O_APPEND, 0600)
+	require.Nil(t, err)
+	journal.WriteString(`{"Key":"num","Val`)
+	journal.Close()
+
+	s2, err := NewStash(filename, false)
+	require.Nil(t, err)
+
+	var foo string
+	require.Nil(t, s2.Read("foo", &foo))
+	require.Equal(t, "baz", foo)
+
+	var num int
+	require.Nil(t, s2.Read("num", &num))
+	require.Equal(t, 42, num)
+}
+
+func TestJournalReplayDeleteRemovesAliases(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".journal")
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+	require.Nil(t, s.Save("target", 1))
+	require.Nil(t, s.Alias("a", "target"))
+
+	s.SetJournal(100)
+	require.Nil(t, s.Delete("target"))
+
+	s2, err := NewStash(filename, false)
+	require.Nil(t, err)
+	require.Empty(t, s2.Aliases())
+
+	require.Nil(t, s2.Save("a", 2))
+	require.False(t, s2.Has("target"))
+}
+
+func TestJournalCompaction(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".journal")
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+	s.SetJournal(2)
+
+	require.Nil(t, s.Save("a", 1))
+	require.Nil(t, s.Save("b", 2))
+
+	// The third change triggers a full flush, which removes the journal
+	require.Nil(t, s.Save("c", 3))
+	_, err = os.Stat(filename + ".journal")
+	require.True(t, os.IsNotExist(err))
+
+	data, err := ioutil.ReadFile(filename)
+	require.Nil(t, err)
+	require.Contains(t, string(data), `{"a":1,"b":2,"c":3}`)
+}
+
+func BenchmarkAutoFlushSave(b *testing.B) {
+	for _, size := range []int{100, 1000, 10000} {
+		for _, journal := range []bool{false, true} {
+			b.Run(fmt.Sprintf("keys=%d/journal=%v", size, journal), func(b *testing.B) {
+				filename := makeTempFilename()
+				defer os.Remove(filename)
+				defer os.Remove(filename + ".journal")
+
+				s, err := NewStash(filename, false)
+				require.Nil(b, err)
+				for i := 0; i < size; i++ {
+					require.Nil(b, s.Save(fmt.Sprintf("key-%d", i), struct1{Foo: "Hello", Bar: true}))
+				}
+				require.Nil(b, s.Flush())
+
+				s, err = NewStash(filename, true)
+				require.Nil(b, err)
+				if journal {
+					s.SetJournal(1000)
+				}
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					s.Save(fmt.Sprintf("key-%d", i%size), i)
+				}
+			})
+		}
+	}
+}
+
+func TestOpenVerboseReportsJournalReplay(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".journal")
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+	s.SetJournal(100)
+	require.Nil(t, s.Save("a", 1))
+	require.Nil(t, s.Save("b", 2))
+
+	journal, err := os.OpenFile(filename+".journal", os.O_WRONLY|os.O_APPEND, 0600)
+	require.Nil(t, err)
+	journal.WriteString(`{"Key":"c"`)
+	journal.Close()
+
+	_, report, err := OpenVerbose(filename, false, Header{})
+	require.Nil(t, err)
+	require.Equal(t, 2, report.Entries)
+	require.Equal(t, []string{"replayed 2 journal entries", "ignored incomplete journal entry"}, report.Recovery)
+}
@@ -0,0 +1,69 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+)
+
+// AdoptFile behaves like NewStash, but also accepts a file containing a plain JSON
+// object that was not written by a Stash, such as an existing configuration file. Each
+// member of the object becomes a key in the Stash, and the file is immediately
+// rewritten in the Stash format. Stash files and missing files are opened as normal.
+func AdoptFile(filename string, autoFlush bool) (*Stash, error) {
+	raw, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return NewStash(filename, autoFlush)
+	} else if err != nil {
+		return nil, err
+	}
+
+	if isContainerFile(raw) {
+		return NewStash(filename, autoFlush)
+	}
+
+	legacy := v1Data{}
+	if err = json.Unmarshal(raw, &legacy); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal legacy file")
+	}
+
+	s := newStash(filename, defaultOptions())
+	s.autoFlush = autoFlush
+	s.version = version1
+	s.data = newShardedData(s.shardCount, legacy)
+	s.revisions.load(nil, nil, 0, legacy)
+	s.opened.Recovery = append(s.opened.Recovery, "adopted legacy file")
+	return s, s.Flush()
+}
+
+// isContainerFile reports whether raw looks like a file written by a Stash, rather than
+// a plain JSON object.
+func isContainerFile(raw []byte) bool {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return false
+	}
+	_, hasVersion := fields["Version"]
+	_, hasData := fields["Data"]
+	return hasVersion && hasData
+}
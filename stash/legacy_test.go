@@ -0,0 +1,72 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdoptFile(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	legacy := `{"name": "example", "port": 8080, "tags": ["a", "b"]}`
+	require.Nil(t, ioutil.WriteFile(filename, []byte(legacy), 0644))
+
+	_, err := NewStash(filename, false)
+	require.NotNil(t, err)
+
+	s, err := AdoptFile(filename, false)
+	require.Nil(t, err)
+
+	var port int
+	require.Nil(t, s.Read("port", &port))
+	require.Equal(t, 8080, port)
+
+	// File has been rewritten as a Stash
+	s, err = NewStash(filename, false)
+	require.Nil(t, err)
+
+	var tags []string
+	require.Nil(t, s.Read("tags", &tags))
+	require.Equal(t, []string{"a", "b"}, tags)
+
+	// Adopting a Stash file leaves it alone
+	require.Nil(t, s.Save("port", 9090))
+	require.Nil(t, s.Flush())
+	s, err = AdoptFile(filename, false)
+	require.Nil(t, err)
+	require.Nil(t, s.Read("port", &port))
+	require.Equal(t, 9090, port)
+}
+
+func TestAdoptFileNotObject(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	require.Nil(t, ioutil.WriteFile(filename, []byte(`[1, 2, 3]`), 0644))
+
+	_, err := AdoptFile(filename, false)
+	require.NotNil(t, err)
+}
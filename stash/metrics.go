@@ -0,0 +1,188 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MetricsSnapshot is the stored form of the metrics saved by a MetricsSnapshotter.
+type MetricsSnapshot struct {
+	Time     time.Time
+	Counters map[string]int64
+	Gauges   map[string]float64
+}
+
+// gauge reads and restores the value of a registered gauge.
+type gauge struct {
+	get     func() float64
+	restore func(float64)
+}
+
+// MetricsSnapshotter saves registered counters and gauges into a Stash, and restores
+// their values when they are registered, so that simple daemons keep cumulative metrics
+// across restarts. Snapshots are taken when Snapshot is called, or periodically after
+// calling Start.
+//
+// Snapshots are saved with Stash.Save, so they are only written to disk by the next
+// Flush unless auto-flush is enabled.
+type MetricsSnapshotter struct {
+	stash *Stash
+	key   string
+
+	mutex    sync.Mutex
+	previous MetricsSnapshot   // guarded by mutex
+	counters map[string]*int64 // guarded by mutex
+	gauges   map[string]gauge  // guarded by mutex
+	lastErr  error             // guarded by mutex
+	stop     chan struct{}     // guarded by mutex
+	stopped  chan struct{}     // guarded by mutex
+}
+
+// NewMetricsSnapshotter returns a MetricsSnapshotter that saves metrics under key in s,
+// after reading any previously saved snapshot.
+func NewMetricsSnapshotter(s *Stash, key string) (*MetricsSnapshotter, error) {
+	m := &MetricsSnapshotter{
+		stash:    s,
+		key:      key,
+		counters: make(map[string]*int64),
+		gauges:   make(map[string]gauge),
+	}
+
+	err := s.Read(key, &m.previous)
+	if _, ok := err.(NoSuchKeyError); ok {
+		err = nil
+	}
+	return m, err
+}
+
+// RegisterCounter registers a counter, which the application must update using the
+// sync/atomic package. If a value for the counter was previously saved, it is stored
+// into counter.
+func (m *MetricsSnapshotter) RegisterCounter(name string, counter *int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if value, ok := m.previous.Counters[name]; ok {
+		atomic.StoreInt64(counter, value)
+	}
+	m.counters[name] = counter
+}
+
+// RegisterGauge registers a gauge, whose value is read by calling get. If a value for
+// the gauge was previously saved and restore is not nil, restore is called with it.
+func (m *MetricsSnapshotter) RegisterGauge(name string, get func() float64, restore func(float64)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if value, ok := m.previous.Gauges[name]; ok && restore != nil {
+		restore(value)
+	}
+	m.gauges[name] = gauge{get: get, restore: restore}
+}
+
+// Snapshot saves the current values of all registered metrics. Values saved previously
+// for metrics that have not been registered are kept.
+func (m *MetricsSnapshotter) Snapshot() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	snapshot := MetricsSnapshot{
+		Time:     time.Now(),
+		Counters: make(map[string]int64),
+		Gauges:   make(map[string]float64),
+	}
+	for name, value := range m.previous.Counters {
+		snapshot.Counters[name] = value
+	}
+	for name, value := range m.previous.Gauges {
+		snapshot.Gauges[name] = value
+	}
+	for name, counter := range m.counters {
+		snapshot.Counters[name] = atomic.LoadInt64(counter)
+	}
+	for name, g := range m.gauges {
+		snapshot.Gauges[name] = g.get()
+	}
+
+	if err := m.stash.Save(m.key, snapshot); err != nil {
+		return err
+	}
+	m.previous = snapshot
+	return nil
+}
+
+// Start takes a snapshot at the given interval until Stop is called. Errors are
+// reported by Err.
+func (m *MetricsSnapshotter) Start(interval time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.stop != nil {
+		return
+	}
+	m.stop = make(chan struct{})
+	m.stopped = make(chan struct{})
+	go m.run(interval, m.stop, m.stopped)
+}
+
+// Stop stops periodic snapshots and takes a final snapshot.
+func (m *MetricsSnapshotter) Stop() error {
+	m.mutex.Lock()
+	stop, stopped := m.stop, m.stopped
+	m.stop, m.stopped = nil, nil
+	m.mutex.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-stopped
+	}
+	return m.Snapshot()
+}
+
+// Err returns the error from the most recent failed periodic snapshot, or nil if the most
+// recent periodic snapshot succeeded.
+func (m *MetricsSnapshotter) Err() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.lastErr
+}
+
+// run takes snapshots until stop is closed, then closes stopped.
+func (m *MetricsSnapshotter) run(interval time.Duration, stop <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			err := m.Snapshot()
+			m.mutex.Lock()
+			m.lastErr = err
+			m.mutex.Unlock()
+		}
+	}
+}
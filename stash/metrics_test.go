@@ -0,0 +1,86 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMetricsSnapshotter(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+
+	m, err := NewMetricsSnapshotter(s, "metrics")
+	require.Nil(t, err)
+
+	var requests int64
+	temperature := 21.5
+	m.RegisterCounter("requests", &requests)
+	m.RegisterGauge("temperature", func() float64 { return temperature }, nil)
+
+	atomic.AddInt64(&requests, 5)
+	m.Start(time.Millisecond)
+	atomic.AddInt64(&requests, 2)
+	require.Nil(t, m.Stop())
+	require.Nil(t, m.Err())
+
+	// Restart and check the values are restored
+	s, err = NewStash(filename, true)
+	require.Nil(t, err)
+
+	m, err = NewMetricsSnapshotter(s, "metrics")
+	require.Nil(t, err)
+
+	var restoredRequests int64
+	var restoredTemperature float64
+	m.RegisterCounter("requests", &restoredRequests)
+	m.RegisterGauge("temperature", func() float64 { return restoredTemperature }, func(v float64) {
+		restoredTemperature = v
+	})
+	require.Equal(t, int64(7), restoredRequests)
+	require.Equal(t, 21.5, restoredTemperature)
+}
+
+func TestMetricsSnapshotterKeepsUnregistered(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+	require.Nil(t, s.Save("metrics", MetricsSnapshot{Counters: map[string]int64{"old": 3}}))
+
+	m, err := NewMetricsSnapshotter(s, "metrics")
+	require.Nil(t, err)
+
+	var current int64 = 1
+	m.RegisterCounter("current", &current)
+	require.Nil(t, m.Snapshot())
+
+	var snapshot MetricsSnapshot
+	require.Nil(t, s.Read("metrics", &snapshot))
+	require.Equal(t, map[string]int64{"old": 3, "current": 1}, snapshot.Counters)
+}
@@ -0,0 +1,182 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"sort"
+	"sync"
+)
+
+// MigrationTx gives a data migration access to the contents of a Stash. Changes made
+// through a MigrationTx only take effect if every migration run at open succeeds.
+type MigrationTx interface {
+	// Read stores the value associated with key into the variable pointed to by ptr.
+	Read(key string, ptr interface{}) error
+
+	// Save associates value with key, overwriting any previous value.
+	Save(key string, value interface{}) error
+
+	// Delete removes key. It returns a NoSuchKeyError if the key does not exist.
+	Delete(key string) error
+
+	// Keys returns the keys currently stored, in sorted order.
+	Keys() []string
+}
+
+// dataMigration upgrades application data from one schema version to another.
+type dataMigration struct {
+	from int
+	to   int
+	fn   func(tx MigrationTx) error
+}
+
+var (
+	migrationsMutex sync.Mutex
+	migrations      []dataMigration
+)
+
+// RegisterDataMigration registers fn to upgrade data from schema version fromSchema to
+// toSchema. Migrations are run by NewStashWithHeader when it opens a file with an
+// older schema version than requested. Where several migrations start at the same
+// version, the one that makes the most progress without overshooting is chosen.
+//
+// RegisterDataMigration panics if toSchema is not greater than fromSchema.
+func RegisterDataMigration(fromSchema, toSchema int, fn func(tx MigrationTx) error) {
+	if toSchema <= fromSchema {
+		panic("stash: data migration must increase the schema version")
+	}
+
+	migrationsMutex.Lock()
+	defer migrationsMutex.Unlock()
+	migrations = append(migrations, dataMigration{from: fromSchema, to: toSchema, fn: fn})
+}
+
+// findMigration returns the registered migration that starts at from and gets closest
+// to target.
+func findMigration(from, target int) (dataMigration, bool) {
+	migrationsMutex.Lock()
+	defer migrationsMutex.Unlock()
+
+	var result dataMigration
+	found := false
+	for _, m := range migrations {
+		if m.from == from && m.to <= target && (!found || m.to > result.to) {
+			result = m
+			found = true
+		}
+	}
+	return result, found
+}
+
+// migrate upgrades the data in s from schema version from to schema version to. The
+// data in s is only replaced if all migrations succeed.
+func (s *Stash) migrate(from, to int) error {
+	data, ok := s.data.(shardedData)
+	if !ok {
+		return UnknownVersionError{s.version}
+	}
+
+	tx := &migrationTx{data: data.merged(), codecs: s.codecs.snapshot()}
+	for current := from; current < to; {
+		m, ok := findMigration(current, to)
+		if !ok {
+			return HeaderMismatchError{
+				Expected: Header{AppID: s.header.AppID, SchemaVersion: to},
+				Found:    Header{AppID: s.header.AppID, SchemaVersion: from},
+			}
+		}
+
+		if err := m.fn(tx); err != nil {
+			return errors.Wrapf(err, "data migration from schema version %d to %d failed", m.from, m.to)
+		}
+		s.opened.Migrations = append(s.opened.Migrations, AppliedMigration{FromSchema: m.from, ToSchema: m.to})
+		current = m.to
+		s.reportProgress(Progress{Op: "Migrate", Processed: current - from, Total: to - from})
+	}
+
+	old := data.merged()
+	for key, value := range old {
+		if newValue, ok := tx.data[key]; !ok {
+			s.revisions.remove(key)
+			s.tags.remove(key)
+		} else if !bytes.Equal(value, newValue) {
+			s.revisions.bump(key)
+		}
+	}
+	for key := range tx.data {
+		if _, ok := old[key]; !ok {
+			s.revisions.bump(key)
+		}
+	}
+	s.aliases.removeTargets(func(target string) bool {
+		_, existed := old[target]
+		_, exists := tx.data[target]
+		return existed && !exists
+	})
+
+	s.data = newShardedData(len(data), tx.data)
+	s.codecs.load(tx.codecs)
+	return nil
+}
+
+// migrationTx implements MigrationTx over a private copy of the data and codecs.
+type migrationTx struct {
+	data   v1Data
+	codecs map[string]string
+}
+
+func (tx *migrationTx) Read(key string, ptr interface{}) error {
+	item, ok := tx.data[key]
+	if !ok {
+		return NoSuchKeyError{key}
+	}
+	return decodeValue(item, tx.codecs[key], ptr)
+}
+
+func (tx *migrationTx) Save(key string, value interface{}) error {
+	marshalledData, err := json.Marshal(value)
+	if err != nil {
+		return errors.Wrap(err, "error marshalling value")
+	}
+	tx.data[key] = marshalledData
+	delete(tx.codecs, key)
+	return nil
+}
+
+func (tx *migrationTx) Delete(key string) error {
+	if _, ok := tx.data[key]; !ok {
+		return NoSuchKeyError{key}
+	}
+	delete(tx.data, key)
+	delete(tx.codecs, key)
+	return nil
+}
+
+func (tx *migrationTx) Keys() []string {
+	result := make([]string, 0, len(tx.data))
+	for key := range tx.data {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,145 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+)
+
+type personV1 struct {
+	Name string
+}
+
+type personV2 struct {
+	First string
+	Last  string
+}
+
+func TestDataMigration(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStashWithHeader(filename, true, Header{AppID: "migration-test", SchemaVersion: 1})
+	require.Nil(t, err)
+	require.Nil(t, s.Save("person", personV1{"Ada Lovelace"}))
+	require.Nil(t, s.Save("obsolete", true))
+	require.Nil(t, s.Alias("retired", "obsolete"))
+	require.Nil(t, s.Alias("ada", "person"))
+
+	RegisterDataMigration(1, 2, func(tx MigrationTx) error {
+		var p personV1
+		if err := tx.Read("person", &p); err != nil {
+			return err
+		}
+		return tx.Save("person", personV2{"Ada", "Lovelace"})
+	})
+	RegisterDataMigration(2, 3, func(tx MigrationTx) error {
+		require.Equal(t, []string{"obsolete", "person"}, tx.Keys())
+		return tx.Delete("obsolete")
+	})
+
+	s, err = NewStashWithHeader(filename, true, Header{AppID: "migration-test", SchemaVersion: 3})
+	require.Nil(t, err)
+	require.Equal(t, 3, s.Header().SchemaVersion)
+
+	// Check the upgraded data was persisted
+	s, err = NewStash(filename, false)
+	require.Nil(t, err)
+	require.Equal(t, 3, s.Header().SchemaVersion)
+
+	var p personV2
+	require.Nil(t, s.Read("person", &p))
+	require.Equal(t, personV2{"Ada", "Lovelace"}, p)
+
+	var obsolete bool
+	_, ok := s.Read("obsolete", &obsolete).(NoSuchKeyError)
+	require.True(t, ok)
+
+	// Aliases of deleted keys are removed with them
+	require.Equal(t, map[string]string{"ada": "person"}, s.Aliases())
+}
+
+func TestDataMigrationMissing(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	_, err := NewStashWithHeader(filename, true, Header{AppID: "migration-test", SchemaVersion: 10})
+	require.Nil(t, err)
+
+	_, err = NewStashWithHeader(filename, true, Header{AppID: "migration-test", SchemaVersion: 11})
+	require.NotNil(t, err)
+	_, ok := err.(HeaderMismatchError)
+	require.True(t, ok)
+}
+
+func TestDataMigrationFailureLeavesDataUnchanged(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStashWithHeader(filename, true, Header{AppID: "migration-test", SchemaVersion: 20})
+	require.Nil(t, err)
+	require.Nil(t, s.Save("foo", "bar"))
+
+	RegisterDataMigration(20, 21, func(tx MigrationTx) error {
+		tx.Save("foo", "changed")
+		return errors.New("failed")
+	})
+
+	_, err = NewStashWithHeader(filename, true, Header{AppID: "migration-test", SchemaVersion: 21})
+	require.NotNil(t, err)
+
+	s, err = NewStash(filename, false)
+	require.Nil(t, err)
+	require.Equal(t, 20, s.Header().SchemaVersion)
+
+	var foo string
+	require.Nil(t, s.Read("foo", &foo))
+	require.Equal(t, "bar", foo)
+}
+
+func TestRegisterDataMigrationPanics(t *testing.T) {
+	require.Panics(t, func() {
+		RegisterDataMigration(2, 2, func(tx MigrationTx) error { return nil })
+	})
+}
+
+func TestOpenVerboseReportsMigrations(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	_, report, err := OpenVerbose(filename, true, Header{AppID: "migration-test", SchemaVersion: 30})
+	require.Nil(t, err)
+	require.True(t, report.Created)
+	require.Nil(t, report.Migrations)
+
+	RegisterDataMigration(30, 31, func(tx MigrationTx) error { return nil })
+	RegisterDataMigration(31, 32, func(tx MigrationTx) error { return nil })
+
+	_, report, err = OpenVerbose(filename, true, Header{AppID: "migration-test", SchemaVersion: 32})
+	require.Nil(t, err)
+	require.False(t, report.Created)
+	require.Equal(t, version1, report.FormatVersion)
+	require.Equal(t, Header{AppID: "migration-test", SchemaVersion: 32}, report.Header)
+	require.Equal(t, []AppliedMigration{{30, 31}, {31, 32}}, report.Migrations)
+	require.True(t, report.Duration > 0)
+}
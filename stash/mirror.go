@@ -0,0 +1,117 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// mirror copies flushed file contents to a secondary path in the background.
+type mirror struct {
+	path    string
+	mode    os.FileMode
+	pending chan []byte // holds the most recent contents not yet written
+	done    chan struct{}
+
+	mutex   sync.Mutex
+	lastErr error // guarded by mutex
+}
+
+// newMirror starts a mirror that writes to path, creating files with the given mode.
+func newMirror(path string, mode os.FileMode) *mirror {
+	m := &mirror{path: path, mode: mode, pending: make(chan []byte, 1), done: make(chan struct{})}
+	go m.run()
+	return m
+}
+
+// submit queues data to be written, replacing any data that has not been written yet.
+// Callers must not call submit concurrently.
+func (m *mirror) submit(data []byte) {
+	select {
+	case <-m.pending:
+	default:
+	}
+	m.pending <- data
+}
+
+// stop waits for queued data to be written, then stops the mirror.
+func (m *mirror) stop() {
+	close(m.pending)
+	<-m.done
+}
+
+// run writes queued data until the mirror is stopped.
+func (m *mirror) run() {
+	defer close(m.done)
+
+	for data := range m.pending {
+		err := m.write(data)
+		m.mutex.Lock()
+		m.lastErr = err
+		m.mutex.Unlock()
+	}
+}
+
+// write replaces the mirror file with data, via a temporary file so that the mirror
+// file is never left partially written.
+func (m *mirror) write(data []byte) error {
+	temp := m.path + ".tmp"
+	if err := ioutil.WriteFile(temp, data, m.mode); err != nil {
+		return errors.Wrapf(err, "failed to write mirror '%s'", m.path)
+	}
+	return errors.Wrapf(os.Rename(temp, m.path), "failed to write mirror '%s'", m.path)
+}
+
+// SetMirror arranges for the contents of the file to be copied to path, such as a
+// different disk, after every successful Flush. Copies are made in the background so
+// they do not slow down Flush; if the Stash is flushed again before a copy is made, only
+// the latest contents are copied. Changes held in a write journal are not mirrored
+// until the next full flush.
+//
+// An empty path stops mirroring, after waiting for any pending copy to be made.
+func (s *Stash) SetMirror(path string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.mirror != nil {
+		s.mirror.stop()
+		s.mirror = nil
+	}
+	if path != "" {
+		s.mirror = newMirror(path, s.fileMode)
+	}
+}
+
+// MirrorErr returns the error from the most recent failed copy to the mirror path, or
+// nil if the most recent copy succeeded or mirroring is not enabled.
+func (s *Stash) MirrorErr() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.mirror == nil {
+		return nil
+	}
+	s.mirror.mutex.Lock()
+	defer s.mirror.mutex.Unlock()
+	return s.mirror.lastErr
+}
@@ -0,0 +1,77 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestMirror(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+	mirrorFilename := makeTempFilename()
+	defer os.Remove(mirrorFilename)
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+	s.SetMirror(mirrorFilename)
+
+	require.Nil(t, s.Save("foo", "bar"))
+	require.Nil(t, s.Save("baz", 42))
+
+	// Stopping the mirror waits for pending copies
+	s.SetMirror("")
+	require.Nil(t, s.MirrorErr())
+
+	original, err := ioutil.ReadFile(filename)
+	require.Nil(t, err)
+	mirrored, err := ioutil.ReadFile(mirrorFilename)
+	require.Nil(t, err)
+	require.Equal(t, original, mirrored)
+
+	s2, err := NewStash(mirrorFilename, false)
+	require.Nil(t, err)
+
+	var baz int
+	require.Nil(t, s2.Read("baz", &baz))
+	require.Equal(t, 42, baz)
+}
+
+func TestMirrorError(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+	s.SetMirror(makeTempFilename() + "/not/a/directory")
+	require.Nil(t, s.Save("foo", "bar"))
+
+	s.mutex.Lock()
+	m := s.mirror
+	s.mutex.Unlock()
+	m.stop()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	require.NotNil(t, m.lastErr)
+}
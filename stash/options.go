@@ -0,0 +1,129 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"os"
+)
+
+// ErrReadOnly is returned when attempting to change a Stash opened with WithReadOnly.
+var ErrReadOnly = errors.New("stash is read-only")
+
+// Option configures a Stash opened with Open.
+type Option func(*options)
+
+// options holds the settings made by Options.
+type options struct {
+	autoFlush bool
+	fileMode  os.FileMode
+	readOnly  bool
+	codec     Codec
+	header    Header
+	progress  func(Progress)
+	durable   bool
+
+	shardCount    int
+	journalLimit  int
+	compressor    Compressor
+	deterministic bool
+	checksum      bool
+}
+
+// defaultOptions returns the settings used when no Options are given.
+func defaultOptions() options {
+	return options{fileMode: 0600, shardCount: defaultShardCount}
+}
+
+// WithAutoFlush controls whether every change is automatically followed by a call to
+// Flush. It is disabled by default.
+func WithAutoFlush(autoFlush bool) Option {
+	return func(o *options) {
+		o.autoFlush = autoFlush
+	}
+}
+
+// WithFileMode sets the permissions used when creating the file and its companion
+// files, such as the write journal. The default is 0600. Existing files keep their
+// permissions.
+func WithFileMode(mode os.FileMode) Option {
+	return func(o *options) {
+		o.fileMode = mode
+	}
+}
+
+// WithReadOnly opens the Stash for reading only. The file must already exist. Any
+// attempt to change the Stash, or to Flush it, returns ErrReadOnly. Data migrations are
+// still run when opening, but their results are only held in memory.
+func WithReadOnly() Option {
+	return func(o *options) {
+		o.readOnly = true
+	}
+}
+
+// WithCodec sets the codec used to encode values saved by Save and similar methods,
+// as if each were saved with SaveWithCodec. Values saved with any codec can always be
+// read. The default is JSONCodec.
+func WithCodec(codec Codec) Option {
+	return func(o *options) {
+		o.codec = codec
+	}
+}
+
+// WithHeader records header in the file, as described for NewStashWithHeader.
+func WithHeader(header Header) Option {
+	return func(o *options) {
+		o.header = header
+	}
+}
+
+// Open constructs a Stash backed by the specified file on disk, configured by opts. If
+// the file exists, it is read into memory; otherwise, an empty Stash is created, and
+// written to disk if auto-flush is enabled. With no options, Open behaves like
+// NewStash with auto-flush disabled.
+//
+//	s, err := stash.Open(filename, stash.WithAutoFlush(true), stash.WithFileMode(0640))
+func Open(filename string, opts ...Option) (*Stash, error) {
+	s, _, err := openVerbose(filename, opts)
+	return s, err
+}
+
+// marshal encodes value with the Stash's default codec, as set by WithCodec. It also
+// returns the name of the codec, which is "" for JSON.
+func (s *Stash) marshal(value interface{}) (json.RawMessage, string, error) {
+	if s.codec == nil || s.codec.Name() == JSONCodec.Name() {
+		marshalledData, err := json.Marshal(value)
+		return marshalledData, "", err
+	}
+	return marshalWithCodec(value, s.codec)
+}
+
+// checkWritable returns ErrClosed if the Stash has been closed, or ErrReadOnly if it
+// was opened with WithReadOnly.
+func (s *Stash) checkWritable() error {
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	return nil
+}
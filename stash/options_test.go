@@ -0,0 +1,125 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenDefaults(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := Open(filename)
+	require.Nil(t, err)
+	require.Nil(t, s.Save("key", 1))
+
+	// Auto-flush is disabled by default
+	_, err = os.Stat(filename)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestOpenWithAutoFlushAndFileMode(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".journal")
+
+	s, err := Open(filename, WithAutoFlush(true), WithFileMode(0640))
+	require.Nil(t, err)
+
+	info, err := os.Stat(filename)
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0640), info.Mode().Perm())
+
+	s.SetJournal(10)
+	require.Nil(t, s.Save("key", 1))
+	info, err = os.Stat(filename + ".journal")
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0640), info.Mode().Perm())
+
+	defer os.Remove(filename + ".mirror")
+	s.SetMirror(filename + ".mirror")
+	require.Nil(t, s.Flush())
+	s.SetMirror("")
+	info, err = os.Stat(filename + ".mirror")
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0640), info.Mode().Perm())
+}
+
+func TestOpenWithReadOnly(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	_, err := Open(filename, WithReadOnly())
+	require.True(t, os.IsNotExist(err))
+
+	s, err := Open(filename, WithAutoFlush(true))
+	require.Nil(t, err)
+	require.Nil(t, s.Save("key", 1))
+
+	s, err = Open(filename, WithReadOnly(), WithAutoFlush(true))
+	require.Nil(t, err)
+
+	var result int
+	require.Nil(t, s.Read("key", &result))
+	require.Equal(t, 1, result)
+
+	require.Equal(t, ErrReadOnly, s.Save("key", 2))
+	require.Equal(t, ErrReadOnly, s.Delete("key"))
+	require.Equal(t, ErrReadOnly, s.Clear())
+	require.Equal(t, ErrReadOnly, s.Alias("alias", "key"))
+	require.Equal(t, ErrReadOnly, s.Flush())
+	require.Nil(t, s.Read("key", &result))
+	require.Equal(t, 1, result)
+}
+
+func TestOpenWithCodec(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := Open(filename, WithCodec(GobCodec))
+	require.Nil(t, err)
+	require.Nil(t, s.Save("gob", []string{"a"}))
+	require.Nil(t, s.SaveWithCodec("json", []string{"b"}, JSONCodec))
+
+	data, err := s.SerializeToBytes()
+	require.Nil(t, err)
+	require.Contains(t, string(data), `"Codecs":{"gob":"gob"}`)
+	require.Contains(t, string(data), `"json":["b"]`)
+
+	var result []string
+	require.Nil(t, s.Read("gob", &result))
+	require.Equal(t, []string{"a"}, result)
+}
+
+func TestOpenWithHeader(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := Open(filename, WithHeader(Header{AppID: "app"}), WithAutoFlush(true))
+	require.Nil(t, err)
+	require.Equal(t, "app", s.Header().AppID)
+
+	_, err = Open(filename, WithHeader(Header{AppID: "other"}))
+	require.IsType(t, HeaderMismatchError{}, err)
+}
@@ -0,0 +1,68 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+// LoadProfile describes how a Stash is expected to be used, so that it can be tuned
+// accordingly. See WithLoadProfile.
+type LoadProfile int
+
+const (
+	// Balanced suits a mix of reads and writes. It is the default.
+	Balanced LoadProfile = iota
+
+	// ReadHeavy suits Stashes that are mostly read. Reads do not depend on the number
+	// of shards, and never wait for locks unless values have been saved with a codec
+	// other than JSON, so this currently uses the same settings as Balanced; it is
+	// provided so that applications can state their intent.
+	ReadHeavy
+
+	// WriteHeavy suits Stashes that are written often by many goroutines. More shards
+	// are used, so that concurrent writes rarely contend, and if auto-flush is enabled,
+	// changes are journaled and the whole file is only rewritten after every 1000
+	// changes, as if SetJournal(1000) had been called.
+	WriteHeavy
+)
+
+// profileShardCounts gives the number of shards used for each LoadProfile.
+var profileShardCounts = map[LoadProfile]int{
+	Balanced:   defaultShardCount,
+	ReadHeavy:  defaultShardCount,
+	WriteHeavy: 128,
+}
+
+// writeHeavyJournalLimit is the number of changes journaled between full flushes by the
+// WriteHeavy profile.
+const writeHeavyJournalLimit = 1000
+
+// WithLoadProfile tunes the Stash for the given pattern of use. The settings it makes
+// can be refined by later options and methods, such as SetJournal. The benchmarks in
+// this package compare the profiles.
+func WithLoadProfile(profile LoadProfile) Option {
+	return func(o *options) {
+		if n, ok := profileShardCounts[profile]; ok {
+			o.shardCount = n
+		}
+		if profile == WriteHeavy {
+			o.journalLimit = writeHeavyJournalLimit
+		} else {
+			o.journalLimit = 0
+		}
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var loadProfiles = map[string]LoadProfile{
+	"Balanced":   Balanced,
+	"ReadHeavy":  ReadHeavy,
+	"WriteHeavy": WriteHeavy,
+}
+
+func TestLoadProfile(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".journal")
+
+	s, err := Open(filename, WithAutoFlush(true), WithLoadProfile(WriteHeavy))
+	require.Nil(t, err)
+	require.Len(t, s.data.(shardedData), 128)
+
+	// Changes are journaled rather than rewriting the file
+	require.Nil(t, s.Save("key", 1))
+	_, err = os.Stat(filename + ".journal")
+	require.Nil(t, err)
+
+	s, err = Open(filename, WithLoadProfile(ReadHeavy))
+	require.Nil(t, err)
+	require.Len(t, s.data.(shardedData), defaultShardCount)
+
+	var result int
+	require.Nil(t, s.Read("key", &result))
+	require.Equal(t, 1, result)
+}
+
+// openWithProfile returns a Stash using profile, populated with n keys.
+func openWithProfile(b *testing.B, filename string, profile LoadProfile, n int) *Stash {
+	s, err := Open(filename, WithLoadProfile(profile))
+	require.Nil(b, err)
+
+	entries := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		entries[fmt.Sprintf("key-%d", i)] = i
+	}
+	require.Nil(b, s.SaveAll(entries))
+	return s
+}
+
+func BenchmarkLoadProfileParallelSave(b *testing.B) {
+	for name, profile := range loadProfiles {
+		b.Run(name, func(b *testing.B) {
+			filename := makeTempFilename()
+			defer os.Remove(filename)
+			s := openWithProfile(b, filename, profile, 1000)
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := rand.Int()
+				for pb.Next() {
+					s.Save(fmt.Sprintf("key-%d", i%1000), i)
+					i++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkLoadProfileParallelRead(b *testing.B) {
+	for name, profile := range loadProfiles {
+		b.Run(name, func(b *testing.B) {
+			filename := makeTempFilename()
+			defer os.Remove(filename)
+			s := openWithProfile(b, filename, profile, 1000)
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := rand.Int()
+				for pb.Next() {
+					var result int
+					s.Read(fmt.Sprintf("key-%d", i%1000), &result)
+					i++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkLoadProfileKeys(b *testing.B) {
+	for name, profile := range loadProfiles {
+		b.Run(name, func(b *testing.B) {
+			filename := makeTempFilename()
+			defer os.Remove(filename)
+			s := openWithProfile(b, filename, profile, 10000)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				s.Keys()
+			}
+		})
+	}
+}
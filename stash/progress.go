@@ -0,0 +1,46 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+// Progress describes how far a long-running operation has got. See WithProgress.
+type Progress struct {
+	Op        string // "Load", "Flush", "Migrate", "Archive" or "Unarchive"
+	Processed int    // entries processed so far; for Migrate, schema versions upgraded
+	Total     int    // total to be processed, or -1 if not known in advance
+	Bytes     int64  // bytes read or written so far, where known
+}
+
+// WithProgress arranges for fn to be called as long-running operations progress, so
+// that applications can display progress bars. fn is called when the file is loaded
+// and after each flush, after each data migration step, and after each entry written
+// by Archive or read by Unarchive. fn is called synchronously, and must not call
+// methods on the Stash.
+func WithProgress(fn func(Progress)) Option {
+	return func(o *options) {
+		o.progress = fn
+	}
+}
+
+// reportProgress passes p to the progress callback, if there is one.
+func (s *Stash) reportProgress(p Progress) {
+	if s.progress != nil {
+		s.progress(p)
+	}
+}
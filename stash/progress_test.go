@@ -0,0 +1,76 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgress(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	var reports []Progress
+	record := WithProgress(func(p Progress) {
+		reports = append(reports, p)
+	})
+
+	s, err := Open(filename, record)
+	require.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		require.Nil(t, s.Save(fmt.Sprintf("key-%d", i), i))
+	}
+	require.Nil(t, s.Flush())
+
+	require.Len(t, reports, 1)
+	require.Equal(t, "Flush", reports[0].Op)
+	require.Equal(t, 3, reports[0].Processed)
+	require.Equal(t, 3, reports[0].Total)
+	info, err := os.Stat(filename)
+	require.Nil(t, err)
+	require.Equal(t, info.Size(), reports[0].Bytes)
+
+	reports = nil
+	s, err = Open(filename, record)
+	require.Nil(t, err)
+	require.Equal(t, []Progress{{Op: "Load", Processed: 3, Total: 3, Bytes: info.Size()}}, reports)
+
+	reports = nil
+	var buf bytes.Buffer
+	require.Nil(t, s.Archive(&buf))
+	require.Len(t, reports, 3)
+	require.Equal(t, "Archive", reports[2].Op)
+	require.Equal(t, 3, reports[2].Processed)
+	require.Equal(t, 3, reports[2].Total)
+
+	reports = nil
+	otherFile := makeTempFilename()
+	defer os.Remove(otherFile)
+	other, err := Open(otherFile, record)
+	require.Nil(t, err)
+	require.Nil(t, other.Unarchive(&buf))
+	require.Len(t, reports, 3)
+	require.Equal(t, Progress{Op: "Unarchive", Processed: 3, Total: -1, Bytes: 3}, reports[2])
+}
@@ -0,0 +1,124 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+//go:build go1.18
+// +build go1.18
+
+package stash
+
+import (
+	"sync"
+)
+
+// QueueOrder determines the order in which a Queue returns its items.
+type QueueOrder int
+
+const (
+	// FIFO queues return the oldest item first.
+	FIFO QueueOrder = iota
+
+	// LIFO queues return the newest item first.
+	LIFO
+)
+
+// Queue is a persisted queue of values of type T. The whole queue is stored as a single
+// entry in a Stash, so each operation is applied (and, with auto-flush or journaling,
+// persisted) atomically. Queues are intended for small numbers of items; every operation
+// costs time proportional to the length of the queue.
+//
+// It is safe for multiple goroutines to use a Queue concurrently, but there must only be
+// one Queue for each key.
+type Queue[T any] struct {
+	mutex sync.Mutex
+	stash *Stash
+	key   string
+	order QueueOrder
+}
+
+// NewQueue returns a Queue stored under key in the given Stash.
+func NewQueue[T any](s *Stash, key string, order QueueOrder) *Queue[T] {
+	return &Queue[T]{stash: s, key: key, order: order}
+}
+
+// Enqueue adds value to the queue.
+func (q *Queue[T]) Enqueue(value T) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	items, err := q.load()
+	if err != nil {
+		return err
+	}
+	return q.stash.Save(q.key, append(items, value))
+}
+
+// Dequeue removes and returns the next item from the queue. If the queue is empty, ok
+// is false.
+func (q *Queue[T]) Dequeue() (value T, ok bool, err error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	items, err := q.load()
+	if err != nil || len(items) == 0 {
+		return value, false, err
+	}
+
+	if q.order == FIFO {
+		value, items = items[0], items[1:]
+	} else {
+		value, items = items[len(items)-1], items[:len(items)-1]
+	}
+	return value, true, q.stash.Save(q.key, items)
+}
+
+// Peek returns the next item in the queue without removing it. If the queue is empty,
+// ok is false.
+func (q *Queue[T]) Peek() (value T, ok bool, err error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	items, err := q.load()
+	if err != nil || len(items) == 0 {
+		return value, false, err
+	}
+
+	if q.order == FIFO {
+		return items[0], true, nil
+	}
+	return items[len(items)-1], true, nil
+}
+
+// Len returns the number of items in the queue.
+func (q *Queue[T]) Len() (int, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	items, err := q.load()
+	return len(items), err
+}
+
+// load reads the items in the queue. A missing key is an empty queue.
+func (q *Queue[T]) load() ([]T, error) {
+	var items []T
+	err := q.stash.Read(q.key, &items)
+	if _, ok := err.(NoSuchKeyError); ok {
+		return nil, nil
+	}
+	return items, err
+}
@@ -0,0 +1,93 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+//go:build go1.18
+// +build go1.18
+
+package stash
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+)
+
+func TestQueueFIFO(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+
+	q := NewQueue[struct1](s, "jobs", FIFO)
+	require.Nil(t, q.Enqueue(struct1{Foo: "first"}))
+	require.Nil(t, q.Enqueue(struct1{Foo: "second"}))
+
+	// Reopen to check the queue was persisted
+	s, err = NewStash(filename, true)
+	require.Nil(t, err)
+	q = NewQueue[struct1](s, "jobs", FIFO)
+
+	n, err := q.Len()
+	require.Nil(t, err)
+	require.Equal(t, 2, n)
+
+	item, ok, err := q.Peek()
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.Equal(t, "first", item.Foo)
+
+	item, ok, err = q.Dequeue()
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.Equal(t, "first", item.Foo)
+
+	item, ok, err = q.Dequeue()
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.Equal(t, "second", item.Foo)
+
+	_, ok, err = q.Dequeue()
+	require.Nil(t, err)
+	require.False(t, ok)
+}
+
+func TestQueueLIFO(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+
+	q := NewQueue[int](s, "stack", LIFO)
+	for i := 1; i <= 3; i++ {
+		require.Nil(t, q.Enqueue(i))
+	}
+
+	for i := 3; i >= 1; i-- {
+		item, ok, err := q.Dequeue()
+		require.Nil(t, err)
+		require.True(t, ok)
+		require.Equal(t, i, item)
+	}
+
+	_, ok, err := q.Peek()
+	require.Nil(t, err)
+	require.False(t, ok)
+}
@@ -0,0 +1,65 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"time"
+)
+
+// WindowCounter is the state of a fixed-window rate limiter, as stored by AllowN.
+type WindowCounter struct {
+	Start time.Time // start of the current window
+	Count int       // events allowed in the current window
+}
+
+// now returns the current time. It is a variable so tests can control the clock.
+var now = time.Now
+
+// AllowN reports whether n events may happen now for the rate limiter stored under key,
+// allowing at most limit events in each window. Allowed events are recorded. The limiter
+// state is stored as a WindowCounter, so limits survive a restart if the Stash has been
+// flushed.
+func (s *Stash) AllowN(key string, n int, limit int, window time.Duration) (bool, error) {
+	allowed := false
+	err := s.modify(key, "", func(old json.RawMessage, exists bool) (json.RawMessage, error) {
+		var counter WindowCounter
+		if exists {
+			if err := json.Unmarshal(old, &counter); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal rate limiter state")
+			}
+		}
+
+		t := now()
+		if t.Sub(counter.Start) >= window || t.Before(counter.Start) {
+			counter = WindowCounter{Start: t}
+		}
+
+		if counter.Count+n > limit {
+			return nil, nil
+		}
+
+		allowed = true
+		counter.Count += n
+		return json.Marshal(counter)
+	})
+	return allowed, err
+}
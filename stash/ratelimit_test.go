@@ -0,0 +1,86 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAllowN(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	clock := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+
+	allowed, err := s.AllowN("client1", 2, 3, time.Minute)
+	require.Nil(t, err)
+	require.True(t, allowed)
+
+	allowed, err = s.AllowN("client1", 2, 3, time.Minute)
+	require.Nil(t, err)
+	require.False(t, allowed)
+
+	allowed, err = s.AllowN("client2", 3, 3, time.Minute)
+	require.Nil(t, err)
+	require.True(t, allowed)
+
+	// The state survives reopening
+	s, err = NewStash(filename, true)
+	require.Nil(t, err)
+
+	allowed, err = s.AllowN("client1", 1, 3, time.Minute)
+	require.Nil(t, err)
+	require.True(t, allowed)
+
+	allowed, err = s.AllowN("client1", 1, 3, time.Minute)
+	require.Nil(t, err)
+	require.False(t, allowed)
+
+	// A new window resets the count
+	clock = clock.Add(time.Minute)
+	allowed, err = s.AllowN("client1", 3, 3, time.Minute)
+	require.Nil(t, err)
+	require.True(t, allowed)
+
+	var counter WindowCounter
+	require.Nil(t, s.Read("client1", &counter))
+	require.Equal(t, 3, counter.Count)
+	require.True(t, clock.Equal(counter.Start))
+}
+
+func TestAllowNBadState(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+	require.Nil(t, s.Save("client", "not a counter"))
+
+	_, err = s.AllowN("client", 1, 1, time.Minute)
+	require.NotNil(t, err)
+}
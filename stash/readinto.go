@@ -0,0 +1,63 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+//go:build go1.18
+// +build go1.18
+
+package stash
+
+import "github.com/pkg/errors"
+
+// ReadInto returns the value associated with the key, decoded as a T. Unlike Read, the
+// destination type is checked at compile time.
+//
+//	foo, err := stash.ReadInto[MyStruct](s, "myKey")
+func ReadInto[T any](s *Stash, key string) (T, error) {
+	var result T
+	err := s.Read(key, &result)
+	return result, err
+}
+
+// AggregateAs behaves like Aggregate, but decodes each value as a T before passing it to
+// fn, and keeps the result as an A. Keys are selected by match, which is given the key;
+// a nil match selects every key. A value that cannot be decoded as a T is returned as an
+// error.
+//
+//	total, err := stash.AggregateAs(s, 0, nil, func(acc int, key string, n int) int {
+//		return acc + n
+//	})
+func AggregateAs[T, A any](s *Stash, initial A, match func(key string) bool, fn func(acc A, key string, value T) A) (A, error) {
+	entries, codecs, keys, err := s.sortedEntriesWithCodecs()
+	if err != nil {
+		return initial, err
+	}
+
+	acc := initial
+	for _, key := range keys {
+		if match != nil && !match(key) {
+			continue
+		}
+		var value T
+		if err = decodeValue(entries[key], codecs[key], &value); err != nil {
+			return acc, errors.Wrapf(err, "failed to decode value of key '%s'", key)
+		}
+		acc = fn(acc, key, value)
+	}
+	return acc, nil
+}
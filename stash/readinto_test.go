@@ -0,0 +1,72 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+//go:build go1.18
+// +build go1.18
+
+package stash
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestReadInto(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+
+	s1 := struct1{Foo: "Hello", Bar: true}
+	require.Nil(t, s.Save("key", s1))
+
+	result, err := ReadInto[struct1](s, "key")
+	require.Nil(t, err)
+	require.Equal(t, s1, result)
+
+	_, err = ReadInto[struct1](s, "missing")
+	_, ok := err.(NoSuchKeyError)
+	require.True(t, ok)
+}
+
+func TestAggregateAs(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+	require.Nil(t, s.Save("order-1", 10))
+	require.Nil(t, s.Save("order-2", 25))
+	require.Nil(t, s.Save("name", "shop"))
+
+	isOrder := func(key string) bool { return strings.HasPrefix(key, "order-") }
+	total, err := AggregateAs(s, 0, isOrder, func(acc int, key string, value int) int {
+		return acc + value
+	})
+	require.Nil(t, err)
+	require.Equal(t, 35, total)
+
+	_, err = AggregateAs(s, 0, nil, func(acc int, key string, value int) int {
+		return acc + value
+	})
+	require.NotNil(t, err)
+}
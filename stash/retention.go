@@ -0,0 +1,148 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RetentionRule declares how long matching entries are kept. An entry matches if its key
+// begins with Prefix and it is tagged with Tag. An empty Prefix or Tag matches every
+// entry, so a rule with neither applies to the whole Stash.
+type RetentionRule struct {
+	Prefix string
+	Tag    string
+
+	// MaxAge is how long a matching entry is kept after it was last written.
+	MaxAge time.Duration
+}
+
+// matches reports whether the rule applies to key, which has the given sorted tags.
+func (r RetentionRule) matches(key string, tags []string) bool {
+	if !strings.HasPrefix(key, r.Prefix) {
+		return false
+	}
+	if r.Tag == "" {
+		return true
+	}
+	i := sort.SearchStrings(tags, r.Tag)
+	return i < len(tags) && tags[i] == r.Tag
+}
+
+// Sweeper removes entries from a Stash according to retention rules, so that cleanup
+// is declared in one place rather than scattered through an application. Entries are
+// removed when Sweep is called, or periodically after calling Start.
+//
+// If several rules match an entry, the shortest MaxAge applies. Entries last written by
+// a release that did not record update times are never removed, as their age is unknown.
+type Sweeper struct {
+	stash *Stash
+	rules []RetentionRule
+
+	mutex   sync.Mutex
+	lastErr error         // guarded by mutex
+	stop    chan struct{} // guarded by mutex
+	stopped chan struct{} // guarded by mutex
+}
+
+// NewSweeper returns a Sweeper that applies rules to s.
+func NewSweeper(s *Stash, rules ...RetentionRule) *Sweeper {
+	return &Sweeper{stash: s, rules: append([]RetentionRule(nil), rules...)}
+}
+
+// Sweep removes every entry that has outlived a matching rule, along with any aliases
+// referring to it, and returns the number of entries removed. If auto-flush is enabled,
+// the removals are persisted with a single Flush.
+func (w *Sweeper) Sweep() (int, error) {
+	s := w.stash
+	cutoff := now()
+	return s.DeleteWhere(func(key string, _ json.RawMessage) bool {
+		updated := s.revisions.getUpdated(key)
+		if updated.IsZero() {
+			return false
+		}
+
+		tags := s.tags.get(key)
+		for _, rule := range w.rules {
+			if rule.matches(key, tags) && cutoff.Sub(updated) > rule.MaxAge {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Start sweeps at the given interval until Stop is called. Errors are reported by Err.
+func (w *Sweeper) Start(interval time.Duration) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.stop != nil {
+		return
+	}
+	w.stop = make(chan struct{})
+	w.stopped = make(chan struct{})
+	go w.run(interval, w.stop, w.stopped)
+}
+
+// Stop stops periodic sweeps, waiting for any sweep in progress to finish.
+func (w *Sweeper) Stop() {
+	w.mutex.Lock()
+	stop, stopped := w.stop, w.stopped
+	w.stop, w.stopped = nil, nil
+	w.mutex.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-stopped
+	}
+}
+
+// Err returns the error from the most recent failed periodic sweep, or nil if the most
+// recent periodic sweep succeeded.
+func (w *Sweeper) Err() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.lastErr
+}
+
+// run sweeps until stop is closed, then closes stopped.
+func (w *Sweeper) run(interval time.Duration, stop <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			_, err := w.Sweep()
+			w.mutex.Lock()
+			w.lastErr = err
+			w.mutex.Unlock()
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSweep(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	clock := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+
+	require.Nil(t, s.Save("log:old", 1))
+	require.Nil(t, s.SaveWithOptions("cache", 2, SaveOptions{Tags: []string{"temp"}}))
+	require.Nil(t, s.SaveWithOptions("log:temp", 3, SaveOptions{Tags: []string{"temp"}}))
+	require.Nil(t, s.Save("other", 4))
+	require.Nil(t, s.Alias("latest", "log:old"))
+
+	clock = clock.Add(2 * time.Hour)
+	require.Nil(t, s.Save("log:new", 5))
+
+	w := NewSweeper(s,
+		RetentionRule{Prefix: "log:", MaxAge: 30 * 24 * time.Hour},
+		RetentionRule{Tag: "temp", MaxAge: time.Hour},
+	)
+
+	// The shortest matching rule applies
+	n, err := w.Sweep()
+	require.Nil(t, err)
+	require.Equal(t, 2, n)
+	require.Equal(t, []string{"log:new", "log:old", "other"}, s.Keys())
+
+	clock = clock.Add(30 * 24 * time.Hour)
+	n, err = w.Sweep()
+	require.Nil(t, err)
+	require.Equal(t, 1, n)
+	require.Equal(t, []string{"log:new", "other"}, s.Keys())
+	require.Empty(t, s.Aliases())
+}
+
+func TestSweeperStart(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+	require.Nil(t, s.Save("a", 1))
+
+	w := NewSweeper(s, RetentionRule{MaxAge: 0})
+	w.Start(time.Millisecond)
+	w.Start(time.Millisecond)
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Has("a") && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	w.Stop()
+	w.Stop()
+	require.Nil(t, w.Err())
+
+	// Removals were flushed
+	s, err = NewStash(filename, true)
+	require.Nil(t, err)
+	require.False(t, s.Has("a"))
+}
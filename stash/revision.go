@@ -0,0 +1,258 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ConflictError indicates that SaveIfRevision was given a revision that is no longer
+// current, because another writer has changed the entry.
+type ConflictError struct {
+	s string
+}
+
+func (e ConflictError) Error() string {
+	return fmt.Sprintf("key '%s' has been changed by another writer", e.s)
+}
+
+// revisionTable holds the revision number of each key and the time it was last written.
+// Every write takes the next revision from a counter shared by all keys, so a key that
+// is deleted and recreated never reuses a revision. Revisions must only be changed while
+// holding the lock of the key's shard, so that they change atomically with the key's
+// value.
+type revisionTable struct {
+	mutex     sync.Mutex
+	revisions map[string]uint64
+	updated   map[string]time.Time
+	last      uint64
+}
+
+// get returns the revision of key, which is zero if the key does not exist.
+func (r *revisionTable) get(key string) uint64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.revisions[key]
+}
+
+// getUpdated returns the time key was last written, which is the zero time if the key
+// does not exist or was last written before update times were recorded.
+func (r *revisionTable) getUpdated(key string) time.Time {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.updated[key]
+}
+
+// bump gives key the next revision and records its update time, following a write.
+func (r *revisionTable) bump(key string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.revisions == nil {
+		r.revisions = make(map[string]uint64)
+	}
+	if r.updated == nil {
+		r.updated = make(map[string]time.Time)
+	}
+	r.last++
+	r.revisions[key] = r.last
+	r.updated[key] = now()
+}
+
+// remove forgets the revision and update time of key, following its deletion.
+func (r *revisionTable) remove(key string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.revisions, key)
+	delete(r.updated, key)
+}
+
+// load replaces the revisions and update times with those read from disk. last is the
+// highest revision issued when the data was written; the counter never goes backwards,
+// so revisions issued before a Clear or Reload are not reused. Keys in data with no
+// recorded revision, such as those written by releases that did not record revisions,
+// are given new revisions so that they are not mistaken for absent keys.
+func (r *revisionTable) load(revisions map[string]uint64, updated map[string]time.Time, last uint64, data v1Data) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if last > r.last {
+		r.last = last
+	}
+	for _, rev := range revisions {
+		if rev > r.last {
+			r.last = rev
+		}
+	}
+	for key := range data {
+		if revisions[key] == 0 {
+			if revisions == nil {
+				revisions = make(map[string]uint64)
+			}
+			r.last++
+			revisions[key] = r.last
+		}
+	}
+	r.revisions = revisions
+	r.updated = updated
+}
+
+// snapshot returns copies of the revisions and update times, and the highest revision
+// issued, for writing to disk.
+func (r *revisionTable) snapshot() (map[string]uint64, map[string]time.Time, uint64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var revisions map[string]uint64
+	if len(r.revisions) > 0 {
+		revisions = make(map[string]uint64, len(r.revisions))
+		for key, rev := range r.revisions {
+			revisions[key] = rev
+		}
+	}
+
+	var updated map[string]time.Time
+	if len(r.updated) > 0 {
+		updated = make(map[string]time.Time, len(r.updated))
+		for key, t := range r.updated {
+			updated[key] = t
+		}
+	}
+	return revisions, updated, r.last
+}
+
+// Revision returns the revision number of the key, which increases every time the key is
+// written, or zero if the key does not exist. Revisions are saved with the data, and are
+// never reused: a key that is deleted and written again gets a higher revision than it
+// had before.
+func (s *Stash) Revision(key string) uint64 {
+	data, ok := s.data.(shardedData)
+	if !ok {
+		return 0
+	}
+
+	key = s.aliases.resolve(key)
+	sh := data.shardFor(key)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+	return s.revisions.get(key)
+}
+
+// ReadRevision behaves like Read, but also returns the revision of the value that was
+// read, for use with SaveIfRevision. The value and revision are read atomically.
+func (s *Stash) ReadRevision(key string, ptr interface{}) (uint64, error) {
+	if v := reflect.ValueOf(ptr); v.Kind() != reflect.Ptr || v.IsNil() {
+		return 0, ErrInvalidDest
+	}
+	if err := s.checkOpen(); err != nil {
+		return 0, err
+	}
+	data, ok := s.data.(shardedData)
+	if !ok {
+		return 0, UnknownVersionError{s.version}
+	}
+
+	key = s.aliases.resolve(key)
+	sh := data.shardFor(key)
+	sh.mutex.Lock()
+	item, exists := sh.get()[key]
+	rev := s.revisions.get(key)
+	codecName := s.codecs.get(key)
+	sh.mutex.Unlock()
+
+	if !exists {
+		return 0, NoSuchKeyError{key}
+	}
+	s.access.record(key, false)
+	return rev, decodeValue(item, codecName, ptr)
+}
+
+// SaveIfRevision behaves like Save, but only if the key's current revision is rev,
+// allowing optimistic concurrency: read a value and its revision with ReadRevision,
+// compute a new value, then save it with SaveIfRevision, retrying if another writer got
+// there first. A rev of zero saves the value only if the key does not exist. If the
+// revision does not match, a ConflictError is returned and nothing is saved.
+func (s *Stash) SaveIfRevision(key string, value interface{}, rev uint64) error {
+	marshalledData, codecName, err := s.marshal(value)
+	if err != nil {
+		return errors.Wrap(err, "error marshalling value")
+	}
+
+	key = s.aliases.resolve(key)
+	saved, err := s.storeRawIf(key, marshalledData, codecName, func(exists bool) bool {
+		if rev == 0 {
+			return !exists
+		}
+		return s.revisions.get(key) == rev
+	})
+	if err != nil {
+		return err
+	} else if !saved {
+		return ConflictError{key}
+	}
+
+	if s.autoFlush {
+		return s.flushChange(key)
+	}
+	return nil
+}
+
+// Updated returns the time the key was last written. The zero time is returned if the
+// key does not exist, or was last written by a release that did not record update times.
+func (s *Stash) Updated(key string) time.Time {
+	data, ok := s.data.(shardedData)
+	if !ok {
+		return time.Time{}
+	}
+
+	key = s.aliases.resolve(key)
+	sh := data.shardFor(key)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+	return s.revisions.getUpdated(key)
+}
+
+// KeysByUpdated returns up to limit keys, ordered by the time they were last written,
+// from the most recent if desc is true, or the least recent otherwise. Keys written at
+// the same time are ordered by key. If limit is zero or less, every key is returned.
+// Update times are saved with the data, so no values need to be decoded.
+func (s *Stash) KeysByUpdated(desc bool, limit int) []string {
+	keys := s.Keys()
+	updated := make(map[string]time.Time, len(keys))
+	for _, key := range keys {
+		updated[key] = s.revisions.getUpdated(key)
+	}
+
+	sort.SliceStable(keys, func(i, j int) bool {
+		ti, tj := updated[keys[i]], updated[keys[j]]
+		if desc {
+			return ti.After(tj)
+		}
+		return ti.Before(tj)
+	})
+
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys
+}
@@ -0,0 +1,206 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveIfRevision(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+	require.Equal(t, uint64(0), s.Revision("key"))
+
+	// Zero only succeeds for a new key
+	require.Nil(t, s.SaveIfRevision("key", "a", 0))
+	require.Equal(t, ConflictError{"key"}, s.SaveIfRevision("key", "b", 0))
+
+	var value string
+	rev, err := s.ReadRevision("key", &value)
+	require.Nil(t, err)
+	require.Equal(t, uint64(1), rev)
+	require.Equal(t, "a", value)
+
+	require.Nil(t, s.Save("key", "c"))
+	require.Equal(t, ConflictError{"key"}, s.SaveIfRevision("key", "d", rev))
+	require.Nil(t, s.SaveIfRevision("key", "d", rev+1))
+
+	// Revisions are persisted
+	s, err = NewStash(filename, true)
+	require.Nil(t, err)
+	require.Equal(t, uint64(3), s.Revision("key"))
+
+	require.Nil(t, s.Delete("key"))
+	require.Equal(t, uint64(0), s.Revision("key"))
+}
+
+func TestSaveIfRevisionAfterRecreate(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+	require.Nil(t, s.Save("key", "a"))
+	rev := s.Revision("key")
+
+	// A key deleted and written again must not match a revision read before
+	require.Nil(t, s.Delete("key"))
+	require.Nil(t, s.Save("key", "b"))
+	require.True(t, s.Revision("key") > rev)
+	require.Equal(t, ConflictError{"key"}, s.SaveIfRevision("key", "c", rev))
+
+	// Nor after the Stash is reopened or cleared
+	rev = s.Revision("key")
+	s, err = NewStash(filename, true)
+	require.Nil(t, err)
+	require.Nil(t, s.Clear())
+	require.Nil(t, s.Save("key", "d"))
+	require.Equal(t, ConflictError{"key"}, s.SaveIfRevision("key", "e", rev))
+
+	var value string
+	require.Nil(t, s.Read("key", &value))
+	require.Equal(t, "d", value)
+}
+
+func TestSaveIfRevisionAfterRecreateDeterministic(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	// Revisions are not written in deterministic mode
+	s, err := Open(filename, WithAutoFlush(true), WithDeterministic())
+	require.Nil(t, err)
+	require.Nil(t, s.Save("key", "a"))
+	rev := s.Revision("key")
+	require.Nil(t, s.Delete("key"))
+
+	s, err = Open(filename, WithAutoFlush(true), WithDeterministic())
+	require.Nil(t, err)
+	require.Nil(t, s.Save("key", "b"))
+	require.Equal(t, ConflictError{"key"}, s.SaveIfRevision("key", "c", rev))
+}
+
+func TestSaveIfRevisionJournaled(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".journal")
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+	s.SetJournal(100)
+	require.Nil(t, s.Save("key", 1))
+	require.Nil(t, s.Save("key", 2))
+
+	s, err = NewStash(filename, false)
+	require.Nil(t, err)
+	require.Equal(t, uint64(2), s.Revision("key"))
+}
+
+func TestSaveIfRevisionWithoutStoredRevision(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	// Files written before revisions were recorded have none
+	require.Nil(t, ioutil.WriteFile(filename, []byte(`{"Version":1,"Data":{"k":"old"}}`), 0600))
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+	require.Equal(t, uint64(1), s.Revision("k"))
+	require.Equal(t, ConflictError{"k"}, s.SaveIfRevision("k", "clobbered", 0))
+
+	var value string
+	rev, err := s.ReadRevision("k", &value)
+	require.Nil(t, err)
+	require.Nil(t, s.SaveIfRevision("k", "new", rev))
+	require.Equal(t, uint64(2), s.Revision("k"))
+}
+
+func TestSaveIfRevisionConcurrent(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+	require.Nil(t, s.Save("counter", 0))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				for {
+					var counter int
+					rev, err := s.ReadRevision("counter", &counter)
+					assert.Nil(t, err)
+					err = s.SaveIfRevision("counter", counter+1, rev)
+					if _, conflict := err.(ConflictError); !conflict {
+						assert.Nil(t, err)
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	var counter int
+	require.Nil(t, s.Read("counter", &counter))
+	require.Equal(t, 100, counter)
+}
+
+func TestKeysByUpdated(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	defer func() { now = time.Now }()
+	clock := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+	require.Nil(t, s.Save("b", 1))
+	require.Nil(t, s.Save("a", 1))
+	require.Nil(t, s.Save("c", 1))
+	require.Nil(t, s.Save("b", 2))
+
+	require.Equal(t, []string{"b", "c", "a"}, s.KeysByUpdated(true, 0))
+	require.Equal(t, []string{"a", "c"}, s.KeysByUpdated(false, 2))
+	require.Equal(t, time.Date(2017, 1, 1, 0, 0, 4, 0, time.UTC), s.Updated("b"))
+
+	// Update times are persisted
+	s, err = NewStash(filename, false)
+	require.Nil(t, err)
+	require.Equal(t, []string{"b", "c", "a"}, s.KeysByUpdated(true, 0))
+	require.True(t, s.Updated("b").Equal(time.Date(2017, 1, 1, 0, 0, 4, 0, time.UTC)))
+	require.True(t, s.Updated("missing").IsZero())
+}
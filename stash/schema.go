@@ -0,0 +1,134 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// Schema summarises the shape of a set of JSON values, loosely following JSON Schema, so
+// that it can be marshalled and read by people familiar with that format.
+type Schema struct {
+	Types      []string           `json:"type"`                 // JSON types seen, sorted
+	Properties map[string]*Schema `json:"properties,omitempty"` // schemas of object members
+	Required   []string           `json:"required,omitempty"`   // members present in every object, sorted
+	Items      *Schema            `json:"items,omitempty"`      // schema of array elements
+
+	types   map[string]bool
+	objects int            // number of objects seen
+	present map[string]int // number of objects each member was present in
+}
+
+// InferSchema analyses the stored values and summarises their shape. Keys are grouped
+// by prefix: the part of the key up to and including the first occurrence of separator,
+// or the whole key if separator does not occur. For example, with a separator of "/",
+// the keys "user/1" and "user/2" are both summarised under "user/".
+func (s *Stash) InferSchema(separator string) (map[string]*Schema, error) {
+	entries, err := s.decodedEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]*Schema)
+	for key, value := range entries {
+		prefix := key
+		if i := strings.Index(key, separator); separator != "" && i >= 0 {
+			prefix = key[:i+len(separator)]
+		}
+
+		if result[prefix] == nil {
+			result[prefix] = &Schema{}
+		}
+		result[prefix].add(value)
+	}
+
+	for _, schema := range result {
+		schema.finish()
+	}
+	return result, nil
+}
+
+// add merges the shape of value into the schema.
+func (s *Schema) add(value interface{}) {
+	if s.types == nil {
+		s.types = make(map[string]bool)
+	}
+
+	switch v := value.(type) {
+	case nil:
+		s.types["null"] = true
+	case bool:
+		s.types["boolean"] = true
+	case string:
+		s.types["string"] = true
+	case json.Number:
+		if strings.ContainsAny(v.String(), ".eE") {
+			s.types["number"] = true
+		} else {
+			s.types["integer"] = true
+		}
+	case []interface{}:
+		s.types["array"] = true
+		if s.Items == nil {
+			s.Items = &Schema{}
+		}
+		for _, element := range v {
+			s.Items.add(element)
+		}
+	case map[string]interface{}:
+		s.types["object"] = true
+		if s.Properties == nil {
+			s.Properties = make(map[string]*Schema)
+			s.present = make(map[string]int)
+		}
+		s.objects++
+		for name, member := range v {
+			if s.Properties[name] == nil {
+				s.Properties[name] = &Schema{}
+			}
+			s.Properties[name].add(member)
+			s.present[name]++
+		}
+	}
+}
+
+// finish fills in Types and Required from the values added.
+func (s *Schema) finish() {
+	s.Types = make([]string, 0, len(s.types))
+	for t := range s.types {
+		s.Types = append(s.Types, t)
+	}
+	sort.Strings(s.Types)
+
+	s.Required = nil
+	for name, property := range s.Properties {
+		if s.present[name] == s.objects {
+			s.Required = append(s.Required, name)
+		}
+		property.finish()
+	}
+	sort.Strings(s.Required)
+
+	if s.Items != nil {
+		s.Items.finish()
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+)
+
+func TestInferSchema(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+	require.Nil(t, s.Save("user/1", map[string]interface{}{"name": "Ada", "age": 36, "tags": []string{"a"}}))
+	require.Nil(t, s.Save("user/2", map[string]interface{}{"name": "Alan", "score": 1.5, "tags": []string{}}))
+	require.Nil(t, s.Save("user/3", map[string]interface{}{"name": nil}))
+	require.Nil(t, s.Save("version", 3))
+
+	schemas, err := s.InferSchema("/")
+	require.Nil(t, err)
+	require.Len(t, schemas, 2)
+
+	encoded, err := json.Marshal(schemas["version"])
+	require.Nil(t, err)
+	require.Equal(t, `{"type":["integer"]}`, string(encoded))
+
+	encoded, err = json.Marshal(schemas["user/"])
+	require.Nil(t, err)
+	require.Equal(t, `{"type":["object"],"properties":{`+
+		`"age":{"type":["integer"]},`+
+		`"name":{"type":["null","string"]},`+
+		`"score":{"type":["number"]},`+
+		`"tags":{"type":["array"],"items":{"type":["string"]}}},`+
+		`"required":["name"]}`, string(encoded))
+}
+
+func TestInferSchemaNoSeparator(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+	require.Nil(t, s.Save("a/1", true))
+	require.Nil(t, s.Save("a/2", "x"))
+
+	schemas, err := s.InferSchema("")
+	require.Nil(t, err)
+	require.Len(t, schemas, 2)
+	require.Equal(t, []string{"boolean"}, schemas["a/1"].Types)
+}
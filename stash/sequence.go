@@ -0,0 +1,55 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+)
+
+// NextSequence increments the sequence stored under key and returns its new value,
+// starting from 1. The sequence is stored as a JSON number, so it can be inspected with
+// Read. Each new value is written to disk before it is returned, even if auto-flush is
+// disabled, so values are never handed out twice, even after a crash. Unless
+// journaling is enabled, this flushes any other pending changes too. Use WithDurability
+// to also survive power failures.
+func (s *Stash) NextSequence(key string) (uint64, error) {
+	var next uint64
+	err := s.modify(key, "", func(old json.RawMessage, exists bool) (json.RawMessage, error) {
+		var current uint64
+		if exists {
+			if err := json.Unmarshal(old, &current); err != nil {
+				return nil, errors.Wrapf(err, "key '%s' does not hold a sequence", key)
+			}
+		}
+		next = current + 1
+		return json.Marshal(next)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if !s.autoFlush {
+		if err = s.flushChange(s.aliases.resolve(key)); err != nil {
+			return 0, err
+		}
+	}
+	return next, nil
+}
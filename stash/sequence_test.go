@@ -0,0 +1,105 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNextSequence(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+
+	for i := uint64(1); i <= 3; i++ {
+		n, err := s.NextSequence("ids")
+		require.Nil(t, err)
+		require.Equal(t, i, n)
+	}
+
+	// Persisted without an explicit Flush
+	s, err = NewStash(filename, false)
+	require.Nil(t, err)
+	n, err := s.NextSequence("ids")
+	require.Nil(t, err)
+	require.Equal(t, uint64(4), n)
+
+	require.Nil(t, s.Save("name", "not a number"))
+	_, err = s.NextSequence("name")
+	require.NotNil(t, err)
+}
+
+func TestNextSequenceJournaledNewFile(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".journal")
+
+	// The file has never been flushed, so the journal alone cannot be replayed
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+	s.SetJournal(100)
+	n, err := s.NextSequence("ids")
+	require.Nil(t, err)
+	require.Equal(t, uint64(1), n)
+
+	// Reopened without Close
+	s, err = NewStash(filename, false)
+	require.Nil(t, err)
+	n, err = s.NextSequence("ids")
+	require.Nil(t, err)
+	require.Equal(t, uint64(2), n)
+}
+
+func TestNextSequenceConcurrent(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	seen := make(map[uint64]bool)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				n, err := s.NextSequence("ids")
+				assert.Nil(t, err)
+				mutex.Lock()
+				assert.False(t, seen[n])
+				seen[n] = true
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.Len(t, seen, 100)
+	require.True(t, seen[100])
+}
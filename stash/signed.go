@@ -0,0 +1,124 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+//go:build go1.15
+// +build go1.15
+
+package stash
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+)
+
+// ErrInvalidSignature is returned by ImportVerified if a bundle's signature does not
+// match its contents and the given public key.
+var ErrInvalidSignature = errors.New("invalid bundle signature")
+
+// signedBundle is the format written by ExportSigned.
+type signedBundle struct {
+	Entries   json.RawMessage
+	Signature []byte
+}
+
+// ExportSigned writes the contents of the Stash to w as a bundle signed by signer, which
+// can be checked and loaded with ImportVerified. This allows curated contents, such as
+// seed data, to be distributed without the risk of them being altered on the way.
+// RSA (PKCS #1 v1.5 with SHA-256), ECDSA (with SHA-256) and Ed25519 signers are
+// supported.
+func (s *Stash) ExportSigned(w io.Writer, signer crypto.Signer) error {
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
+	data, ok := s.data.(shardedData)
+	if !ok {
+		return UnknownVersionError{s.version}
+	}
+
+	entries, err := json.Marshal(data.merged())
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal entries")
+	}
+
+	var signature []byte
+	if _, ok := signer.Public().(ed25519.PublicKey); ok {
+		signature, err = signer.Sign(rand.Reader, entries, crypto.Hash(0))
+	} else {
+		digest := sha256.Sum256(entries)
+		signature, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to sign bundle")
+	}
+
+	return errors.Wrap(json.NewEncoder(w).Encode(signedBundle{Entries: entries, Signature: signature}),
+		"failed to write bundle")
+}
+
+// ImportVerified reads a bundle written by ExportSigned from r and, if its signature is
+// valid for pub, saves its entries, overwriting existing values with the same keys. If
+// the signature is not valid, ErrInvalidSignature is returned and nothing is saved. pub
+// must be an *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey.
+//
+// If auto-flush is enabled, the entries are persisted with a single Flush.
+func (s *Stash) ImportVerified(r io.Reader, pub crypto.PublicKey) error {
+	var bundle signedBundle
+	if err := json.NewDecoder(r).Decode(&bundle); err != nil {
+		return errors.Wrap(err, "failed to read bundle")
+	}
+
+	digest := sha256.Sum256(bundle.Entries)
+	var valid bool
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		valid = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], bundle.Signature) == nil
+	case *ecdsa.PublicKey:
+		valid = ecdsa.VerifyASN1(key, digest[:], bundle.Signature)
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(key, bundle.Entries, bundle.Signature)
+	default:
+		return errors.Errorf("unsupported public key type %T", pub)
+	}
+	if !valid {
+		return ErrInvalidSignature
+	}
+
+	entries := v1Data{}
+	if err := json.Unmarshal(bundle.Entries, &entries); err != nil {
+		return errors.Wrap(err, "failed to unmarshal bundle entries")
+	}
+
+	for key, value := range entries {
+		if _, err := s.storeRawIf(s.aliases.resolve(key), value, "", func(bool) bool { return true }); err != nil {
+			return err
+		}
+	}
+
+	if len(entries) > 0 && s.autoFlush {
+		return s.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+//go:build go1.15
+// +build go1.15
+
+package stash
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignedBundle(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(t, err)
+
+	for _, signer := range []crypto.Signer{rsaKey, ecdsaKey, ed25519Key} {
+		filename := makeTempFilename()
+		defer os.Remove(filename)
+
+		s, err := NewStash(filename, false)
+		require.Nil(t, err)
+		require.Nil(t, s.Save("seed", []int{1, 2, 3}))
+
+		var buf bytes.Buffer
+		require.Nil(t, s.ExportSigned(&buf, signer))
+		bundle := buf.Bytes()
+
+		otherFile := makeTempFilename()
+		defer os.Remove(otherFile)
+
+		other, err := NewStash(otherFile, false)
+		require.Nil(t, err)
+		require.Nil(t, other.ImportVerified(bytes.NewReader(bundle), signer.Public()))
+
+		var seed []int
+		require.Nil(t, other.Read("seed", &seed))
+		require.Equal(t, []int{1, 2, 3}, seed)
+
+		// Tampering is detected
+		tampered := bytes.Replace(bundle, []byte("[1,2,3]"), []byte("[1,2,4]"), 1)
+		require.Equal(t, ErrInvalidSignature, other.ImportVerified(bytes.NewReader(tampered), signer.Public()))
+	}
+}
+
+func TestSignedBundleWrongKey(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(t, err)
+	otherPub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(t, err)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+	require.Nil(t, s.Save("seed", true))
+
+	var buf bytes.Buffer
+	require.Nil(t, s.ExportSigned(&buf, key))
+
+	require.Equal(t, ErrInvalidSignature, s.ImportVerified(&buf, otherPub))
+}
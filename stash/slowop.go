@@ -0,0 +1,60 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"time"
+)
+
+// SlowOp describes a Save, Read or Flush that took longer than the threshold set with
+// SetSlowOpHook.
+type SlowOp struct {
+	Op       string        // "Save", "Read" or "Flush"
+	Key      string        // key for Save and Read, empty for Flush
+	Duration time.Duration // time taken by the operation
+	Bytes    int           // bytes marshalled by Save, unmarshalled by Read or written by Flush
+}
+
+// slowOpHook holds the settings from SetSlowOpHook.
+type slowOpHook struct {
+	threshold time.Duration
+	fn        func(SlowOp)
+}
+
+// SetSlowOpHook arranges for fn to be called whenever a Save, Read or Flush takes longer
+// than threshold, so that performance problems can be logged or reported. Saves that
+// flush to disk include the time taken to flush. fn is called synchronously by the
+// goroutine that performed the operation, after the Stash has released its locks, so it
+// may safely call other Stash methods. A nil fn disables the hook.
+func (s *Stash) SetSlowOpHook(threshold time.Duration, fn func(SlowOp)) {
+	s.slowOps.Store(&slowOpHook{threshold: threshold, fn: fn})
+}
+
+// observe reports the operation to the slow operation hook, if it took too long.
+func (s *Stash) observe(op string, key string, start time.Time, bytes int) {
+	hook, _ := s.slowOps.Load().(*slowOpHook)
+	if hook == nil || hook.fn == nil {
+		return
+	}
+
+	if duration := time.Since(start); duration > hook.threshold {
+		hook.fn(SlowOp{Op: op, Key: key, Duration: duration, Bytes: bytes})
+	}
+}
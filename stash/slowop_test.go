@@ -0,0 +1,62 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSlowOpHook(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+
+	var ops []SlowOp
+	s.SetSlowOpHook(-1, func(op SlowOp) {
+		ops = append(ops, op)
+	})
+
+	require.Nil(t, s.Save("key", "value"))
+
+	var value string
+	require.Nil(t, s.Read("key", &value))
+
+	require.Len(t, ops, 3)
+	require.Equal(t, "Flush", ops[0].Op)
+	require.True(t, ops[0].Bytes > 0)
+	require.Equal(t, SlowOp{Op: "Save", Key: "key", Duration: ops[1].Duration, Bytes: len(`"value"`)}, ops[1])
+	require.Equal(t, SlowOp{Op: "Read", Key: "key", Duration: ops[2].Duration, Bytes: len(`"value"`)}, ops[2])
+
+	// Nothing is reported below the threshold
+	ops = nil
+	s.SetSlowOpHook(time.Hour, func(op SlowOp) {
+		ops = append(ops, op)
+	})
+	require.Nil(t, s.Save("key", "value"))
+	require.Len(t, ops, 0)
+
+	s.SetSlowOpHook(0, nil)
+	require.Nil(t, s.Save("key", "value"))
+}
@@ -21,18 +21,37 @@
 package stash
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"github.com/pkg/errors"
+	"hash/fnv"
 	"io/ioutil"
 	"os"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
 	version1 = 1
+
+	// version2 files hold version 1 data, along with a checksum of the data, and are
+	// written when WithChecksum is used. Older releases would keep the checksum while
+	// rewriting the data, so they must not write to these files.
+	version2 = 2
 )
 
+// defaultShardCount is the number of shards the in-memory data is split into.
+const defaultShardCount = 32
+
+// ErrInvalidDest is returned when the destination passed to Read is nil or is not a pointer
+var ErrInvalidDest = errors.New("destination must be a non-nil pointer")
+
 // UnknownVersionError indicates an unsupported version number tag was found in the data
 type UnknownVersionError struct {
 	badVersion int
@@ -51,27 +70,411 @@ func (e NoSuchKeyError) Error() string {
 	return fmt.Sprintf("no such key: %s", e.s)
 }
 
+// DigestMismatchError indicates that a pre-marshalled value did not match its digest
+type DigestMismatchError struct {
+	s string
+}
+
+func (e DigestMismatchError) Error() string {
+	return fmt.Sprintf("digest mismatch for value of key: %s", e.s)
+}
+
+// KeyExistsError indicates that a key already exists in the database
+type KeyExistsError struct {
+	s string
+}
+
+func (e KeyExistsError) Error() string {
+	return fmt.Sprintf("key already exists: %s", e.s)
+}
+
+// HeaderMismatchError indicates that a file belongs to a different application, or uses
+// a different application schema version, to the one expected
+type HeaderMismatchError struct {
+	Expected Header
+	Found    Header
+}
+
+func (e HeaderMismatchError) Error() string {
+	return fmt.Sprintf("file has application '%s' schema version %d, expected application '%s' schema version %d",
+		e.Found.AppID, e.Found.SchemaVersion, e.Expected.AppID, e.Expected.SchemaVersion)
+}
+
+// OpenReport describes what happened while opening a Stash with OpenVerbose.
+type OpenReport struct {
+	Created       bool               // a new, empty data store was created
+	FormatVersion int                // file format version
+	Header        Header             // header after any adoption or migration
+	Entries       int                // number of entries loaded
+	Migrations    []AppliedMigration // data migrations that were run, in order
+	Recovery      []string           // descriptions of any recovery actions taken
+	Duration      time.Duration      // time taken to open the Stash
+}
+
+// AppliedMigration identifies a data migration that was run while opening a Stash.
+type AppliedMigration struct {
+	FromSchema int
+	ToSchema   int
+}
+
+// Header identifies the application that owns a Stash file and the version of the
+// application's data schema. Both are stored in the file alongside the data.
+type Header struct {
+	AppID         string
+	SchemaVersion int
+}
+
 // Stash is a simple in-memory data store, backed by a file on disk. Create a Stash by calling
 // the NewStash factory method. It is safe for multiple goroutines to call a Stash's methods
 // concurrently.
 type Stash struct {
-	mutex     *sync.Mutex // protects access to the file
+	mutex     *sync.Mutex // protects access to the file; shards guard their own data
 	file      string
 	version   int
 	autoFlush bool
 	data      interface{}
+	cache     entryCache                 // guarded by mutex
+	highWater int64                      // guarded by mutex
+	header    Header                     // guarded by mutex
+	extra     map[string]json.RawMessage // unrecognised container fields, written back on Flush
+
+	access    accessTracker
+	aliases   aliasTable
+	revisions revisionTable
+	tags      tagTable
+	codecs    codecTable
+	defaults  defaultRegistry
+	slowOps   atomic.Value // holds a *slowOpHook
+	freeze    sync.RWMutex // held for reading while writing to disk, for writing while frozen
+
+	mirror *mirror    // guarded by mutex
+	opened OpenReport // filled in while opening
+
+	written os.FileInfo // the file as the Stash last wrote it, if at all; guarded by mutex
+
+	journal        *os.File // open journal file, if any; guarded by mutex
+	journalLimit   int      // guarded by mutex
+	journalEntries int      // guarded by mutex
+
+	dryRun func(VerifyReport) // guarded by mutex
+	faults FaultInjector      // guarded by mutex
+
+	fileMode os.FileMode // permissions for files created by the Stash
+	readOnly bool
+	codec    Codec          // default codec for Save, or nil for JSON
+	progress func(Progress) // progress callback, or nil
+	closed   int32          // set atomically by Close
+	durable  bool           // sync files to disk when writing
+
+	shardCount int        // number of shards to split the data into when loading
+	compressor Compressor // compresses the data in the file, if not nil
+	checksum   bool       // write a checksum of the data, in a version 2 file
 }
 
 // container is used when writing to disk, to store the data format version
 // alongside the marshalled data.
+//
+// Fields that are not recognised when reading are assumed to be optional additions
+// made by a newer release using the same Version. They are kept in Extra and written
+// back unchanged, so that different releases sharing a file do not discard each
+// other's additions. Changes that older releases cannot safely ignore require a new
+// Version.
 type container struct {
-	Version int
-	Data    json.RawMessage
+	Version       int
+	AppID         string `json:",omitempty"`
+	SchemaVersion int    `json:",omitempty"`
+	Data          json.RawMessage
+	Accessed      map[string]time.Time       `json:",omitempty"`
+	Usage         map[string]KeyUsage        `json:",omitempty"`
+	Aliases       map[string]string          `json:",omitempty"`
+	Revisions     map[string]uint64          `json:",omitempty"`
+	LastRevision  uint64                     `json:",omitempty"`
+	Updated       map[string]time.Time       `json:",omitempty"`
+	Tags          map[string][]string        `json:",omitempty"`
+	Codecs        map[string]string          `json:",omitempty"`
+	Checksum      string                     `json:",omitempty"`
+	Compression   string                     `json:",omitempty"`
+	Extra         map[string]json.RawMessage `json:"-"`
+}
+
+// containerFields has the same fields as container, without the custom marshalling.
+type containerFields container
+
+// isContainerField reports whether name is the JSON name of a known container field.
+func isContainerField(name string) bool {
+	t := reflect.TypeOf(containerFields{})
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("json") != "-" && strings.EqualFold(t.Field(i).Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// MarshalJSON implements json.Marshaler, writing the known fields and any extra fields.
+func (c container) MarshalJSON() ([]byte, error) {
+	known, err := json.Marshal(containerFields(c))
+	if err != nil || len(c.Extra) == 0 {
+		return known, err
+	}
+
+	merged := map[string]json.RawMessage{}
+	if err = json.Unmarshal(known, &merged); err != nil {
+		return nil, err
+	}
+	for name, value := range c.Extra {
+		merged[name] = value
+	}
+	return json.Marshal(merged)
+}
+
+// UnmarshalJSON implements json.Unmarshaler, keeping any unrecognised fields in Extra.
+func (c *container) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, (*containerFields)(c)); err != nil {
+		return err
+	}
+
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return err
+	}
+
+	c.Extra = nil
+	for name, value := range all {
+		if !isContainerField(name) {
+			if c.Extra == nil {
+				c.Extra = make(map[string]json.RawMessage)
+			}
+			c.Extra[name] = value
+		}
+	}
+	return nil
 }
 
 // v1Data is the version 1 data format - a simple map of strings to marshalled JSON data.
 type v1Data map[string]json.RawMessage
 
+// shard holds a portion of the in-memory data. Readers load an immutable snapshot
+// without taking any lock; writers serialise on mutex and replace the snapshot with
+// a modified copy.
+type shard struct {
+	mutex    sync.Mutex   // serialises writers
+	snapshot atomic.Value // holds a v1Data, which must not be modified once stored
+}
+
+// get returns the current snapshot of the shard's data. The result must not be modified.
+func (sh *shard) get() v1Data {
+	return sh.snapshot.Load().(v1Data)
+}
+
+// update replaces the shard's data with a copy that has been modified by fn.
+func (sh *shard) update(fn func(data v1Data)) {
+	sh.updateIf(func(v1Data) bool { return true }, fn)
+}
+
+// updateIf behaves like update, but only if cond returns true for the current data. It
+// reports whether the update was made.
+func (sh *shard) updateIf(cond func(data v1Data) bool, fn func(data v1Data)) bool {
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	old := sh.get()
+	if !cond(old) {
+		return false
+	}
+
+	data := make(v1Data, len(old)+1)
+	for key, value := range old {
+		data[key] = value
+	}
+	fn(data)
+	sh.snapshot.Store(data)
+	return true
+}
+
+// shardedData is the in-memory representation of v1Data. Keys are spread across
+// shards by hash, so that operations on different keys rarely contend for the
+// same lock. It marshals to exactly the same JSON as the equivalent v1Data.
+type shardedData []*shard
+
+// newShardedData creates shardedData with n shards, populated with the contents of data.
+func newShardedData(n int, data v1Data) shardedData {
+	parts := make([]v1Data, n)
+	for i := range parts {
+		parts[i] = v1Data{}
+	}
+	for key, value := range data {
+		parts[shardIndex(key, n)][key] = value
+	}
+
+	result := make(shardedData, n)
+	for i := range result {
+		result[i] = &shard{}
+		result[i].snapshot.Store(parts[i])
+	}
+	return result
+}
+
+// replace atomically replaces the contents of each shard with the corresponding
+// entries from data.
+func (d shardedData) replace(data v1Data) {
+	parts := newShardedData(len(d), data)
+	for i, sh := range d {
+		sh.mutex.Lock()
+		sh.snapshot.Store(parts[i].get())
+		sh.mutex.Unlock()
+	}
+}
+
+// shardIndex returns the index of the shard responsible for key, out of n shards.
+func shardIndex(key string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
+
+// shardFor returns the shard responsible for key.
+func (d shardedData) shardFor(key string) *shard {
+	return d[shardIndex(key, len(d))]
+}
+
+// merged returns a copy of the data in all shards as a single v1Data.
+func (d shardedData) merged() v1Data {
+	result := v1Data{}
+	for _, sh := range d {
+		for key, value := range sh.get() {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+// frozen returns shardedData holding the current snapshot of each shard of d, which is
+// unaffected by later changes.
+func (d shardedData) frozen() shardedData {
+	result := make(shardedData, len(d))
+	for i, sh := range d {
+		result[i] = &shard{}
+		result[i].snapshot.Store(sh.get())
+	}
+	return result
+}
+
+// entryCache remembers the encoded form of each entry from the previous Flush, along
+// with the sorted order of the keys, so that a subsequent Flush only encodes the
+// entries that have changed and splices them into the document.
+type entryCache struct {
+	entries    map[string]*cachedEntry
+	keys       []string // sorted keys of entries
+	generation uint64
+	canonical  bool // encode values in canonical form, as described for WithDeterministic
+}
+
+// cachedEntry is the encoded form of a single entry.
+type cachedEntry struct {
+	raw        json.RawMessage // value the fragment was encoded from
+	fragment   []byte          // "key":value, ready to be spliced into the document
+	generation uint64          // last generation in which the entry was present
+}
+
+// encode produces the JSON encoding of d, which is identical to marshalling the
+// equivalent v1Data.
+func (c *entryCache) encode(d shardedData) ([]byte, error) {
+	if c.entries == nil {
+		c.entries = make(map[string]*cachedEntry)
+	}
+	c.generation++
+
+	present := 0
+	resort := false
+	for _, sh := range d {
+		for key, raw := range sh.get() {
+			present++
+			entry, ok := c.entries[key]
+			if !ok {
+				entry = &cachedEntry{}
+				c.entries[key] = entry
+				resort = true
+			}
+			if !ok || !sameBytes(entry.raw, raw) {
+				fragment, err := encodeFragment(key, raw, c.canonical)
+				if err != nil {
+					return nil, err
+				}
+				entry.raw = raw
+				entry.fragment = fragment
+			}
+			entry.generation = c.generation
+		}
+	}
+
+	if present != len(c.entries) {
+		for key, entry := range c.entries {
+			if entry.generation != c.generation {
+				delete(c.entries, key)
+			}
+		}
+		resort = true
+	}
+
+	if resort {
+		c.keys = c.keys[:0]
+		for key := range c.entries {
+			c.keys = append(c.keys, key)
+		}
+		sort.Strings(c.keys)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range c.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(c.entries[key].fragment)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// size returns the number of bytes held by the cache that are not shared with the
+// stored data.
+func (c *entryCache) size() int64 {
+	var result int64
+	for key, entry := range c.entries {
+		result += int64(len(key) + len(entry.fragment))
+	}
+	return result
+}
+
+// encodeFragment encodes a single "key":value pair, with the value in canonical form if
+// canonical is true.
+func encodeFragment(key string, raw json.RawMessage, canonical bool) ([]byte, error) {
+	encodedKey, err := json.Marshal(key)
+	if err != nil {
+		return nil, err
+	}
+	if canonical {
+		if raw, err = canonicalJSON(raw); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.Write(encodedKey)
+	buf.WriteByte(':')
+	if err = json.Compact(&buf, raw); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sameBytes reports whether a and b are the same slice of memory. Stored values
+// are never modified in place, so this is sufficient to detect a changed entry.
+func sameBytes(a, b []byte) bool {
+	return len(a) == len(b) && (len(a) == 0 || &a[0] == &b[0])
+}
+
 // Save associates the value with the key in the data store, overwriting
 // any previous value. If auto-flush is enabled, each call to Save will
 // be persisted to disk immediately. Otherwise, Flush must be called.
@@ -80,18 +483,167 @@ type v1Data map[string]json.RawMessage
 // will not be saved. See the documentation for the json package for more
 // information.
 func (s *Stash) Save(key string, value interface{}) error {
+	start := time.Now()
+	marshalledData, codecName, err := s.marshal(value)
+	if err != nil {
+		return errors.Wrap(err, "error marshalling value")
+	}
+
+	err = s.saveRaw(key, marshalledData, codecName)
+	s.observe("Save", key, start, len(marshalledData))
+	return err
+}
+
+// SavePremarshalled associates an already marshalled JSON value with the key, avoiding
+// the cost of unmarshalling and marshalling it again. The value is only stored if its
+// SHA-256 digest matches sum and it is valid JSON. Otherwise, it behaves like Save.
+func (s *Stash) SavePremarshalled(key string, raw []byte, sum []byte) error {
+	actual := sha256.Sum256(raw)
+	if !bytes.Equal(actual[:], sum) {
+		return DigestMismatchError{key}
+	}
+
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, raw); err != nil {
+		return errors.Wrap(err, "value is not valid JSON")
+	}
+	return s.saveRaw(key, compacted.Bytes(), "")
+}
+
+// SaveIfAbsent behaves like Save, but only if the key does not already exist. It
+// reports whether the value was saved. The check and the save happen atomically, so
+// SaveIfAbsent can be used to ensure only one caller initialises a key.
+func (s *Stash) SaveIfAbsent(key string, value interface{}) (bool, error) {
+	return s.saveIf(key, value, false)
+}
+
+// SaveIfPresent behaves like Save, but only if the key already exists. It reports
+// whether the value was saved. The check and the save happen atomically.
+func (s *Stash) SaveIfPresent(key string, value interface{}) (bool, error) {
+	return s.saveIf(key, value, true)
+}
+
+// GetOrSet stores the value associated with the key into the variable pointed to by
+// ptr, like Read. If the key does not exist, defaultVal is saved first, as by Save, and
+// then read back. It reports whether an existing value was loaded. The check and the
+// save happen atomically, so GetOrSet behaves like sync.Map's LoadOrStore.
+func (s *Stash) GetOrSet(key string, ptr interface{}, defaultVal interface{}) (loaded bool, err error) {
+	if v := reflect.ValueOf(ptr); v.Kind() != reflect.Ptr || v.IsNil() {
+		return false, ErrInvalidDest
+	}
+
+	marshalledData, codecName, err := s.marshal(defaultVal)
+	if err != nil {
+		return false, errors.Wrap(err, "error marshalling value")
+	}
+
+	var current json.RawMessage
+	currentCodec := codecName
+	err = s.modify(key, codecName, func(old json.RawMessage, exists bool) (json.RawMessage, error) {
+		if exists {
+			current, loaded = old, true
+			currentCodec = s.codecs.get(s.aliases.resolve(key))
+			return nil, nil
+		}
+		current = marshalledData
+		return marshalledData, nil
+	})
+	if err != nil {
+		return loaded, err
+	}
+
+	if loaded {
+		s.access.record(s.aliases.resolve(key), false)
+	}
+	return loaded, decodeValue(current, currentCodec, ptr)
+}
+
+// saveIf marshals and saves the value if the presence of the key matches present.
+func (s *Stash) saveIf(key string, value interface{}, present bool) (bool, error) {
+	marshalledData, codecName, err := s.marshal(value)
+	if err != nil {
+		return false, errors.Wrap(err, "error marshalling value")
+	}
+	return s.saveRawIf(key, marshalledData, codecName, func(exists bool) bool {
+		return exists == present
+	})
+}
+
+// saveRaw associates the marshalled value with the key, flushing if necessary. codecName
+// is the codec the value was encoded with, or "" if it is plain JSON.
+func (s *Stash) saveRaw(key string, marshalledData json.RawMessage, codecName string) error {
+	_, err := s.saveRawIf(key, marshalledData, codecName, func(bool) bool { return true })
+	return err
+}
+
+// saveRawIf associates the marshalled value with the key if cond returns true, given
+// whether the key currently exists. It reports whether the value was saved.
+func (s *Stash) saveRawIf(key string, marshalledData json.RawMessage, codecName string, cond func(exists bool) bool) (bool, error) {
+	key = s.aliases.resolve(key)
+	saved, err := s.storeRawIf(key, marshalledData, codecName, cond)
+	if saved && s.autoFlush {
+		return true, s.flushChange(key)
+	}
+	return saved, err
+}
+
+// storeRawIf behaves like saveRawIf, but never flushes and does not resolve aliases.
+func (s *Stash) storeRawIf(key string, marshalledData json.RawMessage, codecName string, cond func(exists bool) bool) (bool, error) {
+	if err := s.checkWritable(); err != nil {
+		return false, err
+	}
 	switch s.version {
 	case version1:
-		marshalledData, err := json.Marshal(value)
-		if err != nil {
-			return errors.Wrap(err, "error marshalling value")
+		saved := s.data.(shardedData).shardFor(key).updateIf(func(data v1Data) bool {
+			_, exists := data[key]
+			return cond(exists)
+		}, func(data v1Data) {
+			data[key] = marshalledData
+			s.revisions.bump(key)
+			s.codecs.set(key, codecName)
+		})
+
+		if saved {
+			s.access.record(key, true)
 		}
-		s.mutex.Lock()
-		s.data.(v1Data)[key] = marshalledData
-		s.mutex.Unlock()
+		return saved, nil
+	default:
+		return false, UnknownVersionError{s.version}
+	}
+}
 
-		if s.autoFlush {
-			return s.Flush()
+// modify atomically replaces the value of key with the result of fn, which is given the
+// current value and whether the key exists. The new value is recorded as encoded with
+// the named codec, or as plain JSON if codecName is "". If fn returns a nil value or an
+// error, the key is left unchanged. Other changes to keys in the same shard wait while
+// fn runs.
+func (s *Stash) modify(key string, codecName string, fn func(old json.RawMessage, exists bool) (json.RawMessage, error)) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	key = s.aliases.resolve(key)
+	switch s.version {
+	case version1:
+		var newValue json.RawMessage
+		var fnErr error
+		modified := s.data.(shardedData).shardFor(key).updateIf(func(data v1Data) bool {
+			old, exists := data[key]
+			newValue, fnErr = fn(old, exists)
+			return fnErr == nil && newValue != nil
+		}, func(data v1Data) {
+			data[key] = newValue
+			s.revisions.bump(key)
+			s.codecs.set(key, codecName)
+		})
+
+		if modified {
+			s.access.record(key, true)
+		}
+
+		if fnErr != nil {
+			return fnErr
+		} else if modified && s.autoFlush {
+			return s.flushChange(key)
 		} else {
 			return nil
 		}
@@ -100,76 +652,511 @@ func (s *Stash) Save(key string, value interface{}) error {
 	}
 }
 
+// Delete removes the key and its value. If the key is an alias, the entry it refers to
+// is removed. Any aliases referring to the removed entry are also removed. Delete
+// returns a NoSuchKeyError if the key does not exist. If auto-flush is enabled, the
+// deletion is persisted to disk immediately.
+func (s *Stash) Delete(key string) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	key = s.aliases.resolve(key)
+	switch s.version {
+	case version1:
+		deleted := s.data.(shardedData).shardFor(key).updateIf(func(data v1Data) bool {
+			_, exists := data[key]
+			return exists
+		}, func(data v1Data) {
+			delete(data, key)
+			s.revisions.remove(key)
+			s.tags.remove(key)
+			s.codecs.remove(key)
+		})
+		if !deleted {
+			return NoSuchKeyError{key}
+		}
+
+		s.aliases.removeTargets(func(target string) bool { return target == key })
+
+		if s.autoFlush {
+			return s.flushChange(key)
+		}
+		return nil
+	default:
+		return UnknownVersionError{s.version}
+	}
+}
+
+// DeleteWhere removes every key for which fn returns true, given the key and its
+// marshalled value, along with any aliases referring to those keys. It returns the
+// number of keys removed. If auto-flush is enabled and any keys were removed, the
+// changes are persisted with a single Flush.
+//
+// fn must not call methods on the Stash. Other changes to keys in the same shard wait
+// while it runs.
+func (s *Stash) DeleteWhere(fn func(key string, raw json.RawMessage) bool) (int, error) {
+	if err := s.checkWritable(); err != nil {
+		return 0, err
+	}
+	switch s.version {
+	case version1:
+		deleted := make(map[string]bool)
+		for _, sh := range s.data.(shardedData) {
+			var matched []string
+			sh.updateIf(func(data v1Data) bool {
+				for key, raw := range data {
+					if fn(key, raw) {
+						matched = append(matched, key)
+					}
+				}
+				return len(matched) > 0
+			}, func(data v1Data) {
+				for _, key := range matched {
+					delete(data, key)
+					s.revisions.remove(key)
+					s.tags.remove(key)
+					s.codecs.remove(key)
+				}
+			})
+			for _, key := range matched {
+				deleted[key] = true
+			}
+		}
+		if len(deleted) == 0 {
+			return 0, nil
+		}
+
+		s.aliases.removeTargets(func(target string) bool { return deleted[target] })
+
+		if s.autoFlush {
+			return len(deleted), s.Flush()
+		}
+		return len(deleted), nil
+	default:
+		return 0, UnknownVersionError{s.version}
+	}
+}
+
+// Clear removes every key and alias from the Stash. If auto-flush is enabled, the empty
+// Stash is persisted to disk immediately.
+func (s *Stash) Clear() error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	switch s.version {
+	case version1:
+		s.data.(shardedData).replace(nil)
+		s.aliases.load(nil)
+		s.revisions.load(nil, nil, 0, nil)
+		s.tags.load(nil)
+		s.codecs.load(nil)
+
+		if s.autoFlush {
+			return s.Flush()
+		}
+		return nil
+	default:
+		return UnknownVersionError{s.version}
+	}
+}
+
+// Has reports whether a value is stored under the key, or under the key it refers to if
+// it is an alias, without decoding the value. Registered defaults are not considered.
+func (s *Stash) Has(key string) bool {
+	data, ok := s.data.(shardedData)
+	if !ok {
+		return false
+	}
+
+	key = s.aliases.resolve(key)
+	_, exists := data.shardFor(key).get()[key]
+	return exists
+}
+
+// Keys returns the keys currently stored, in sorted order. Aliases are not included.
+func (s *Stash) Keys() []string {
+	data, ok := s.data.(shardedData)
+	if !ok {
+		return nil
+	}
+
+	var keys []string
+	for _, sh := range data {
+		for key := range sh.get() {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Len returns the number of keys currently stored. Aliases are not counted.
+func (s *Stash) Len() int {
+	data, ok := s.data.(shardedData)
+	if !ok {
+		return 0
+	}
+
+	n := 0
+	for _, sh := range data {
+		n += len(sh.get())
+	}
+	return n
+}
+
 // Read will store the value associated with the key into the
-// variable pointed to by ptr.
+// variable pointed to by ptr. If ptr is nil or not a pointer,
+// ErrInvalidDest is returned.
 //
-//   var foo MyStruct
-//   err = jd2.Read("myKey", &foo)
-//   if err != nil {
-//     ...
-//   }
+//	var foo MyStruct
+//	err = jd2.Read("myKey", &foo)
+//	if err != nil {
+//	  ...
+//	}
 func (s *Stash) Read(key string, ptr interface{}) error {
+	start := time.Now()
+	n, err := s.read(key, ptr)
+	s.observe("Read", key, start, n)
+	return err
+}
+
+// read is the implementation of Read, returning the number of bytes unmarshalled.
+func (s *Stash) read(key string, ptr interface{}) (int, error) {
+	if v := reflect.ValueOf(ptr); v.Kind() != reflect.Ptr || v.IsNil() {
+		return 0, ErrInvalidDest
+	}
+	if err := s.checkOpen(); err != nil {
+		return 0, err
+	}
+
+	key = s.aliases.resolve(key)
 	switch s.version {
 	case version1:
-		data := s.data.(v1Data)
-		s.mutex.Lock()
-		defer s.mutex.Unlock()
-		if item, ok := data[key]; ok {
-			return json.Unmarshal(item, ptr)
+		if item, codecName, ok := s.entry(s.data.(shardedData), key); ok {
+			s.access.record(key, false)
+			return len(item), decodeValue(item, codecName, ptr)
+		} else if item, codecName, ok := s.defaults.get(key); ok {
+			return len(item), decodeValue(item, codecName, ptr)
 		} else {
-			return NoSuchKeyError{""}
+			return 0, NoSuchKeyError{""}
 		}
 
 	default:
-		return UnknownVersionError{s.version}
+		return 0, UnknownVersionError{s.version}
 	}
 }
 
 // Flush writes the content of the in-memory database to disk. There
 // is no need to call Flush if auto-flushing is enabled.
 func (s *Stash) Flush() error {
+	start := time.Now()
+	s.freeze.RLock()
 	s.mutex.Lock()
-	defer s.mutex.Unlock()
-	jsonData, err := json.Marshal(s.data)
+	n, err := s.flush()
+	s.mutex.Unlock()
+	s.freeze.RUnlock()
+
+	s.observe("Flush", "", start, n)
+	return err
+}
+
+// flush is the implementation of Flush, returning the number of bytes written. The
+// caller must hold s.mutex.
+func (s *Stash) flush() (int, error) {
+	if err := s.checkWritable(); err != nil {
+		return 0, err
+	}
+	jsonFileData, err := s.encodeFile()
+	if err != nil {
+		return 0, err
+	}
+	if s.dryRun != nil {
+		return len(jsonFileData), s.rehearseWrite(jsonFileData)
+	}
+
+	if s.durable {
+		err = s.replaceFile(jsonFileData)
+	} else {
+		err = s.writeFile(s.file, jsonFileData)
+	}
+	if err == nil {
+		n := len(s.cache.keys)
+		s.reportProgress(Progress{Op: "Flush", Processed: n, Total: n, Bytes: int64(len(jsonFileData))})
+	}
+	return len(jsonFileData), s.finishWrite(jsonFileData, err)
+}
+
+// encodeFile produces the contents of the file from the in-memory data. The caller must
+// hold s.mutex.
+func (s *Stash) encodeFile() ([]byte, error) {
+	sharded, ok := s.data.(shardedData)
+	if !ok {
+		return nil, UnknownVersionError{s.version}
+	}
+
+	sharded, codecs := s.snapshotWithCodecs(sharded)
+	jsonData, err := s.cache.encode(sharded)
 	if err != nil {
-		return errors.WithMessage(err, "failed to marshal data")
+		return nil, errors.WithMessage(err, "failed to marshal data")
+	}
+
+	data, compression, err := s.compressData(jsonData)
+	if err != nil {
+		return nil, err
+	}
+
+	version, checksum := s.version, ""
+	if s.checksum && s.version == version1 {
+		// json.Marshal escapes HTML characters in the data, so escape them here to
+		// ensure the checksum covers exactly the bytes that are written.
+		var escaped bytes.Buffer
+		json.HTMLEscape(&escaped, data)
+		data = escaped.Bytes()
+		version, checksum = version2, dataChecksum(data)
 	}
 
-	container := container{Version: s.version, Data: jsonData}
+	accessed, usage := s.access.snapshot(s.data)
+	revisions, updated, lastRevision := s.revisions.snapshot()
+	if s.cache.canonical {
+		accessed, usage, revisions, updated, lastRevision = nil, nil, nil, nil, 0
+	}
+	container := container{
+		Version:       version,
+		AppID:         s.header.AppID,
+		SchemaVersion: s.header.SchemaVersion,
+		Data:          data,
+		Compression:   compression,
+		Checksum:      checksum,
+		Accessed:      accessed,
+		Usage:         usage,
+		Aliases:       s.aliases.get(),
+		Revisions:     revisions,
+		LastRevision:  lastRevision,
+		Updated:       updated,
+		Tags:          s.tags.snapshot(),
+		Codecs:        codecs,
+		Extra:         s.extra,
+	}
 	jsonFileData, err := json.Marshal(container)
+	return jsonFileData, errors.WithMessage(err, "failed to marshal container")
+}
+
+// finishWrite tidies up after jsonFileData has been written to the file, which failed if
+// err is not nil. It returns the error to report to the caller. The caller must hold
+// s.mutex.
+func (s *Stash) finishWrite(jsonFileData []byte, err error) error {
+	if err == nil {
+		if info, statErr := os.Stat(s.file); statErr == nil {
+			s.written = info
+		}
+		err = s.resetJournal()
+	}
+	if err == nil && s.mirror != nil {
+		s.mirror.submit(jsonFileData)
+	}
+
+	if s.highWater > 0 && s.memoryUsage() > s.highWater {
+		s.cache = entryCache{canonical: s.cache.canonical}
+	}
 
-	err = ioutil.WriteFile(s.file, jsonFileData, 0600)
 	return errors.WithMessage(err, fmt.Sprintf("failed to write database to '%s'", s.file))
 }
 
+// MemoryUsage returns an estimate of the number of bytes held in memory by the
+// Stash, including stored keys and values and any caches.
+func (s *Stash) MemoryUsage() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.memoryUsage()
+}
+
+// memoryUsage is the implementation of MemoryUsage. The caller must hold s.mutex.
+func (s *Stash) memoryUsage() int64 {
+	var result int64
+	if data, ok := s.data.(shardedData); ok {
+		for _, sh := range data {
+			for key, value := range sh.get() {
+				result += int64(len(key) + len(value))
+			}
+		}
+	}
+	return result + s.cache.size()
+}
+
+// SetMemoryHighWaterMark sets a limit, in bytes, above which the Stash drops its
+// caches after each Flush to bring memory usage back down. The stored data itself
+// is never discarded. A limit of zero or less disables the check, which is the default.
+func (s *Stash) SetMemoryHighWaterMark(limit int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.highWater = limit
+}
+
 // readFromDisk reads the contents of jd.file into memory. This function will
 // return an error if the file is not a Stash file.
 func (s *Stash) readFromDisk() error {
-	data, err := ioutil.ReadFile(s.file)
+	container, v1data, err := s.readFile(&s.opened.Recovery)
+	if container != nil {
+		s.version = dataVersion(container.Version)
+		s.loadContainer(container, v1data)
+	}
 	if err != nil {
 		return err
 	}
 
-	var container container
-	err = json.Unmarshal(data, &container)
+	s.data = newShardedData(s.shardCount, v1data)
+	if s.progress != nil {
+		var size int64
+		if info, err := os.Stat(s.file); err == nil {
+			size = info.Size()
+		}
+		s.reportProgress(Progress{Op: "Load", Processed: len(v1data), Total: len(v1data), Bytes: size})
+	}
+	return nil
+}
+
+// readFile reads and decodes the contents of s.file, and applies any journaled changes.
+// If the outer data structure can be decoded, it is returned even if there is an error.
+// If recovery is not nil, descriptions of any recovery actions are appended to it.
+func (s *Stash) readFile(recovery *[]string) (*container, v1Data, error) {
+	data, err := ioutil.ReadFile(s.file)
 	if err != nil {
-		return errors.Wrap(err, "failed to unmarshal outer data structure")
+		return nil, nil, err
+	}
+
+	container, v1data, err := decodeFile(data)
+	if err != nil {
+		return container, nil, err
+	}
+	if err = s.replayJournal(v1data, container, recovery); err != nil {
+		return container, nil, err
 	}
+	return container, v1data, nil
+}
 
-	s.version = container.Version
+// decodeFile decodes the contents of a Stash file. If the outer data structure can be
+// decoded, it is returned even if there is an error.
+func decodeFile(data []byte) (*container, v1Data, error) {
+	var container container
+	err := json.Unmarshal(data, &container)
+	if err != nil {
+		if looksTruncated(data) {
+			return nil, nil, CorruptFileError{"file is truncated"}
+		}
+		return nil, nil, errors.Wrap(err, "failed to unmarshal outer data structure")
+	}
+	if container.Version == version2 {
+		if err = checkChecksum(&container); err != nil {
+			return &container, nil, err
+		}
+	}
+	if container.Data, err = decompressData(&container); err != nil {
+		return &container, nil, err
+	}
 
-	switch s.version {
-	case version1:
+	switch container.Version {
+	case version1, version2:
 		v1data := v1Data{}
 		err = json.Unmarshal(container.Data, &v1data)
 		if err != nil {
-			return errors.Wrap(err, "failed to unwrap v1 data")
+			return &container, nil, errors.Wrap(err, "failed to unwrap v1 data")
 		}
-		s.data = v1data
-		return nil
+		return &container, v1data, nil
 	default:
-		return UnknownVersionError{s.version}
+		return &container, nil, UnknownVersionError{container.Version}
+	}
+}
+
+// dataVersion returns the format of the data held in a file with the given version.
+func dataVersion(version int) int {
+	if version == version2 {
+		return version1
 	}
+	return version
+}
+
+// Reload replaces the in-memory contents of the Stash with the contents of the file on
+// disk, discarding any changes that have not been flushed. It is useful when the file
+// may have been changed by another program.
+func (s *Stash) Reload() error {
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
+	s.freeze.RLock()
+	defer s.freeze.RUnlock()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	container, v1data, err := s.readFile(nil)
+	if err != nil {
+		return err
+	}
+
+	data, ok := s.data.(shardedData)
+	if !ok || dataVersion(container.Version) != s.version {
+		return UnknownVersionError{container.Version}
+	}
+
+	data.replace(v1data)
+	s.loadContainer(container, v1data)
+	return nil
+}
+
+// loadContainer replaces the header and other metadata with those read from disk. data
+// is the data read with them, or nil if it could not be decoded. The caller must hold
+// s.mutex, or have sole access to s.
+func (s *Stash) loadContainer(container *container, data v1Data) {
+	s.header = Header{AppID: container.AppID, SchemaVersion: container.SchemaVersion}
+	s.extra = container.Extra
+	s.access.load(container.Accessed, container.Usage)
+	s.aliases.load(container.Aliases)
+	lastRevision := container.LastRevision
+	if s.cache.canonical {
+		// Revisions are not written in deterministic mode, so start from the current
+		// time to stay clear of revisions issued before the file was last written.
+		lastRevision = uint64(now().UnixNano())
+	}
+	s.revisions.load(container.Revisions, container.Updated, lastRevision, data)
+	s.tags.load(container.Tags)
+	s.codecs.load(container.Codecs)
+}
+
+// checkHeader compares the header read from disk with the expected header. An empty
+// AppID or zero SchemaVersion in either header is treated as unset; unset values in
+// the stored header are filled in from the expected header. Data with an older schema
+// version is upgraded using the registered data migrations.
+func (s *Stash) checkHeader(expected Header) error {
+	found := s.header
+
+	if expected.AppID != "" {
+		if found.AppID == "" {
+			s.header.AppID = expected.AppID
+		} else if found.AppID != expected.AppID {
+			return HeaderMismatchError{Expected: expected, Found: found}
+		}
+	}
+
+	if expected.SchemaVersion != 0 {
+		if found.SchemaVersion == 0 {
+			s.header.SchemaVersion = expected.SchemaVersion
+		} else if found.SchemaVersion < expected.SchemaVersion {
+			if err := s.migrate(found.SchemaVersion, expected.SchemaVersion); err != nil {
+				return err
+			}
+			s.header.SchemaVersion = expected.SchemaVersion
+		} else if found.SchemaVersion != expected.SchemaVersion {
+			return HeaderMismatchError{Expected: expected, Found: found}
+		}
+	}
+
+	return nil
+}
+
+// Header returns the application identity and schema version stored with the Stash.
+func (s *Stash) Header() Header {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.header
 }
 
 // NewStash constructs a new Stash, backed by the specified file on disk. If autoFlush is
@@ -179,20 +1166,104 @@ func (s *Stash) readFromDisk() error {
 // If filename points at an existing file, it is assumed to be a Stash file and is
 // read into memory. If the file does not yet exist and autoFlush is enabled, an empty
 // data store will be written to disk.
+//
+// NewStash is equivalent to calling Open with WithAutoFlush(autoFlush). Use Open for
+// further options.
 func NewStash(filename string, autoFlush bool) (*Stash, error) {
-	result := Stash{file: filename, mutex: &sync.Mutex{}, autoFlush: autoFlush}
+	return NewStashWithHeader(filename, autoFlush, Header{})
+}
+
+// NewStashWithHeader behaves like NewStash, but also records header in the file. If the
+// file already exists and belongs to a different application or schema version, a
+// HeaderMismatchError is returned. Files that have no application or schema version
+// recorded are adopted.
+//
+// If the file has an older schema version, the data migrations registered with
+// RegisterDataMigration are run to bring it up to date. If no suitable migrations are
+// registered, a HeaderMismatchError is returned. If autoFlush is enabled, the upgraded
+// data is written to disk immediately.
+func NewStashWithHeader(filename string, autoFlush bool, header Header) (*Stash, error) {
+	s, _, err := OpenVerbose(filename, autoFlush, header)
+	return s, err
+}
+
+// OpenVerbose behaves like NewStashWithHeader, but also returns a report of what happened
+// while opening the file, for applications that want to log it. The report is
+// returned even if there is an error.
+func OpenVerbose(filename string, autoFlush bool, header Header) (*Stash, OpenReport, error) {
+	return openVerbose(filename, []Option{WithAutoFlush(autoFlush), WithHeader(header)})
+}
+
+// openVerbose is the implementation of OpenVerbose and Open.
+func openVerbose(filename string, opts []Option) (*Stash, OpenReport, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	start := time.Now()
+	s, err := open(filename, o)
+
+	report := s.opened
+	report.FormatVersion = s.version
+	report.Header = s.header
+	report.Entries = s.Len()
+	report.Duration = time.Since(start)
+	return s, report, err
+}
+
+// newStash returns an empty Stash backed by filename, configured by o. Its version and
+// data must be set before use.
+func newStash(filename string, o options) *Stash {
+	return &Stash{
+		file:      filename,
+		mutex:     &sync.Mutex{},
+		autoFlush: o.autoFlush && !o.readOnly,
+		header:    o.header,
+		fileMode:  o.fileMode,
+		readOnly:  o.readOnly,
+		codec:     o.codec,
+		progress:  o.progress,
+		durable:   o.durable,
+
+		shardCount:   o.shardCount,
+		journalLimit: o.journalLimit,
+		compressor:   o.compressor,
+		checksum:     o.checksum,
+		cache:        entryCache{canonical: o.deterministic},
+	}
+}
+
+// open opens or creates filename, configured by o.
+func open(filename string, o options) (*Stash, error) {
+	result := newStash(filename, o)
+	autoFlush, header := result.autoFlush, o.header
 
 	if _, err := os.Stat(filename); os.IsNotExist(err) {
 		// new database
+		if o.readOnly {
+			return result, err
+		}
+		result.opened.Created = true
 		result.version = version1
-		result.data = v1Data(make(map[string]json.RawMessage))
+		result.data = newShardedData(result.shardCount, nil)
 		if autoFlush {
-			return &result, result.Flush()
+			return result, result.Flush()
 		} else {
-			return &result, nil
+			return result, nil
 		}
 	} else {
 		// existing database
-		return &result, result.readFromDisk()
+		if err = result.readFromDisk(); err != nil {
+			return result, err
+		}
+		found := result.header
+		if err = result.checkHeader(header); err != nil {
+			return result, err
+		}
+		if autoFlush && result.header != found {
+			return result, result.Flush()
+		}
+		return result, nil
 	}
 }
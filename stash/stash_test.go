@@ -20,6 +20,8 @@
 package stash
 
 import (
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
@@ -27,6 +29,9 @@ import (
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -207,6 +212,18 @@ func TestUnsupportedVersionInFile(t *testing.T) {
 	require.True(t, ok)
 }
 
+func TestFlushUnsupportedVersionInFile(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	require.Nil(t, ioutil.WriteFile(filename, []byte(`{"Version":42,"Data":{}}`), 0600))
+
+	s, err := NewStash(filename, false)
+	require.IsType(t, UnknownVersionError{}, err)
+	require.Equal(t, UnknownVersionError{42}, s.Flush())
+	require.Equal(t, UnknownVersionError{42}, s.Close())
+}
+
 func TestNonExistantKey(t *testing.T) {
 	filename := makeTempFilename()
 	defer os.Remove(filename)
@@ -220,3 +237,506 @@ func TestNonExistantKey(t *testing.T) {
 	_, ok := err.(NoSuchKeyError)
 	require.True(t, ok)
 }
+
+func TestConcurrentSaveAndRead(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				key := fmt.Sprintf("key-%d-%d", i, j)
+				assert.Nil(t, s.Save(key, j))
+
+				var result int
+				assert.Nil(t, s.Read(key, &result))
+				assert.Equal(t, j, result)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	require.Nil(t, s.Flush())
+
+	s2, err := NewStash(filename, false)
+	require.Nil(t, err)
+
+	var result int
+	require.Nil(t, s2.Read("key-9-99", &result))
+	require.Equal(t, 99, result)
+}
+
+func BenchmarkParallelSaveAndRead(b *testing.B) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(b, err)
+
+	b.RunParallel(func(pb *testing.PB) {
+		i := rand.Int()
+		for pb.Next() {
+			key := fmt.Sprintf("key-%d", i%1000)
+			s.Save(key, i)
+
+			var result int
+			s.Read(key, &result)
+			i++
+		}
+	})
+}
+
+func BenchmarkParallelRead(b *testing.B) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(b, err)
+
+	for i := 0; i < 1000; i++ {
+		require.Nil(b, s.Save(fmt.Sprintf("key-%d", i), i))
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := rand.Int()
+		for pb.Next() {
+			var result int
+			s.Read(fmt.Sprintf("key-%d", i%1000), &result)
+			i++
+		}
+	})
+}
+
+func TestEntryCacheMatchesMarshal(t *testing.T) {
+	data := v1Data{
+		"b":   json.RawMessage(`{"x": 1}`),
+		"a<>": json.RawMessage(`"hello"`),
+		"c":   json.RawMessage(`[1, 2, 3]`),
+	}
+	d := newShardedData(4, data)
+
+	var cache entryCache
+	check := func() {
+		expected, err := json.Marshal(data)
+		require.Nil(t, err)
+		result, err := cache.encode(d)
+		require.Nil(t, err)
+		require.Equal(t, string(expected), string(result))
+	}
+	check()
+
+	// Change one entry, add another and check again
+	for _, key := range []string{"b", "aa"} {
+		value := json.RawMessage(`true`)
+		data[key] = value
+		d.shardFor(key).update(func(shardData v1Data) {
+			shardData[key] = value
+		})
+	}
+	check()
+
+	// Remove an entry
+	delete(data, "c")
+	d.shardFor("c").update(func(shardData v1Data) {
+		delete(shardData, "c")
+	})
+	check()
+	require.Len(t, cache.entries, 3)
+}
+
+func TestMemoryUsage(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+	require.Equal(t, int64(0), s.MemoryUsage())
+
+	require.Nil(t, s.Save("key", "value"))
+	withoutCache := s.MemoryUsage()
+	require.Equal(t, int64(len("key")+len(`"value"`)), withoutCache)
+
+	require.Nil(t, s.Flush())
+	require.True(t, s.MemoryUsage() > withoutCache)
+
+	s.SetMemoryHighWaterMark(1)
+	require.Nil(t, s.Flush())
+	require.Equal(t, withoutCache, s.MemoryUsage())
+}
+
+func TestHeader(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	header := Header{AppID: "myapp", SchemaVersion: 3}
+	s, err := NewStashWithHeader(filename, true, header)
+	require.Nil(t, err)
+	require.Equal(t, header, s.Header())
+
+	// Plain NewStash accepts the file and preserves the header
+	s, err = NewStash(filename, true)
+	require.Nil(t, err)
+	require.Equal(t, header, s.Header())
+	require.Nil(t, s.Save("foo", "bar"))
+
+	s, err = NewStashWithHeader(filename, true, header)
+	require.Nil(t, err)
+	require.Equal(t, header, s.Header())
+
+	_, err = NewStashWithHeader(filename, true, Header{AppID: "otherapp", SchemaVersion: 3})
+	require.NotNil(t, err)
+	mismatch, ok := err.(HeaderMismatchError)
+	require.True(t, ok)
+	require.Equal(t, header, mismatch.Found)
+
+	_, err = NewStashWithHeader(filename, true, Header{AppID: "myapp", SchemaVersion: 4})
+	require.NotNil(t, err)
+	_, ok = err.(HeaderMismatchError)
+	require.True(t, ok)
+}
+
+func TestHeaderAdoptsUnmarkedFile(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	_, err := NewStash(filename, true)
+	require.Nil(t, err)
+
+	header := Header{AppID: "myapp", SchemaVersion: 1}
+	s, err := NewStashWithHeader(filename, false, header)
+	require.Nil(t, err)
+	require.Equal(t, header, s.Header())
+}
+
+func TestUnknownContainerFieldsPreserved(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	fileData := `{"Version":1,"Data":{"foo":"bar"},"FutureField":{"a":[1,2]},"futureFlag":true}`
+	require.Nil(t, ioutil.WriteFile(filename, []byte(fileData), 0600))
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+	require.Nil(t, s.Save("baz", 42))
+
+	written, err := ioutil.ReadFile(filename)
+	require.Nil(t, err)
+
+	var fields map[string]json.RawMessage
+	require.Nil(t, json.Unmarshal(written, &fields))
+	require.Equal(t, `{"a":[1,2]}`, string(fields["FutureField"]))
+	require.Equal(t, `true`, string(fields["futureFlag"]))
+	require.Equal(t, `{"baz":42,"foo":"bar"}`, string(fields["Data"]))
+	require.Equal(t, `1`, string(fields["Version"]))
+}
+
+func TestSavePremarshalled(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+
+	raw := []byte(`{ "Foo": "Hello", "Bar": true }`)
+	sum := sha256.Sum256(raw)
+	require.Nil(t, s.SavePremarshalled("key", raw, sum[:]))
+
+	var result struct1
+	require.Nil(t, s.Read("key", &result))
+	require.Equal(t, struct1{Foo: "Hello", Bar: true}, result)
+
+	err = s.SavePremarshalled("key", []byte(`"other"`), sum[:])
+	require.NotNil(t, err)
+	_, ok := err.(DigestMismatchError)
+	require.True(t, ok)
+
+	invalid := []byte(`{"Foo":`)
+	sum = sha256.Sum256(invalid)
+	require.NotNil(t, s.SavePremarshalled("key", invalid, sum[:]))
+}
+
+func TestDigestMismatchErrorString(t *testing.T) {
+	err := DigestMismatchError{"foo"}
+	require.Equal(t, "digest mismatch for value of key: foo", err.Error())
+}
+
+func TestSaveIfAbsent(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+
+	saved, err := s.SaveIfAbsent("leader", "node1")
+	require.Nil(t, err)
+	require.True(t, saved)
+
+	saved, err = s.SaveIfAbsent("leader", "node2")
+	require.Nil(t, err)
+	require.False(t, saved)
+
+	var leader string
+	require.Nil(t, s.Read("leader", &leader))
+	require.Equal(t, "node1", leader)
+}
+
+func TestSaveIfAbsentConcurrent(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+
+	var wg sync.WaitGroup
+	results := make(chan bool, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			saved, err := s.SaveIfAbsent("once", i)
+			assert.Nil(t, err)
+			results <- saved
+		}(i)
+	}
+	wg.Wait()
+	close(results)
+
+	count := 0
+	for saved := range results {
+		if saved {
+			count++
+		}
+	}
+	require.Equal(t, 1, count)
+}
+
+func TestSaveIfPresent(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+
+	saved, err := s.SaveIfPresent("key", 1)
+	require.Nil(t, err)
+	require.False(t, saved)
+
+	var result int
+	_, ok := s.Read("key", &result).(NoSuchKeyError)
+	require.True(t, ok)
+
+	require.Nil(t, s.Save("key", 1))
+	saved, err = s.SaveIfPresent("key", 2)
+	require.Nil(t, err)
+	require.True(t, saved)
+
+	require.Nil(t, s.Read("key", &result))
+	require.Equal(t, 2, result)
+}
+
+func TestReadInvalidDest(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+	require.Nil(t, s.Save("key", "value"))
+
+	var result string
+	require.Equal(t, ErrInvalidDest, s.Read("key", result))
+	require.Equal(t, ErrInvalidDest, s.Read("key", nil))
+
+	var nilPtr *string
+	require.Equal(t, ErrInvalidDest, s.Read("key", nilPtr))
+
+	// The destination is checked before the key
+	require.Equal(t, ErrInvalidDest, s.Read("missing", result))
+}
+
+func TestDelete(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+
+	require.Equal(t, NoSuchKeyError{"key"}, s.Delete("key"))
+
+	require.Nil(t, s.Save("key", 1))
+	require.Nil(t, s.Save("other", 2))
+	require.Nil(t, s.Alias("alias", "key"))
+	require.Nil(t, s.Delete("key"))
+
+	var result int
+	_, ok := s.Read("key", &result).(NoSuchKeyError)
+	require.True(t, ok)
+	require.Empty(t, s.Aliases())
+
+	// Deletion is persisted
+	s, err = NewStash(filename, false)
+	require.Nil(t, err)
+	_, ok = s.Read("key", &result).(NoSuchKeyError)
+	require.True(t, ok)
+	require.Nil(t, s.Read("other", &result))
+	require.Equal(t, 2, result)
+}
+
+func TestClear(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+
+	require.Nil(t, s.Save("a", 1))
+	require.Nil(t, s.Save("b", 2))
+	require.Nil(t, s.Clear())
+
+	s, err = NewStash(filename, false)
+	require.Nil(t, err)
+
+	var result int
+	_, ok := s.Read("a", &result).(NoSuchKeyError)
+	require.True(t, ok)
+	_, ok = s.Read("b", &result).(NoSuchKeyError)
+	require.True(t, ok)
+}
+
+func TestDeleteWhere(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		require.Nil(t, s.Save(fmt.Sprintf("session-%d", i), i))
+	}
+	require.Nil(t, s.Save("config", 100))
+	require.Nil(t, s.Alias("latest", "session-9"))
+
+	n, err := s.DeleteWhere(func(key string, raw json.RawMessage) bool {
+		var value int
+		require.Nil(t, json.Unmarshal(raw, &value))
+		return strings.HasPrefix(key, "session-") && value >= 5
+	})
+	require.Nil(t, err)
+	require.Equal(t, 5, n)
+	require.Empty(t, s.Aliases())
+
+	s, err = NewStash(filename, false)
+	require.Nil(t, err)
+
+	var result int
+	require.Nil(t, s.Read("session-4", &result))
+	_, ok := s.Read("session-5", &result).(NoSuchKeyError)
+	require.True(t, ok)
+	require.Nil(t, s.Read("config", &result))
+
+	n, err = s.DeleteWhere(func(string, json.RawMessage) bool { return false })
+	require.Nil(t, err)
+	require.Equal(t, 0, n)
+}
+
+func TestKeysAndLen(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+	require.Empty(t, s.Keys())
+	require.Equal(t, 0, s.Len())
+
+	require.Nil(t, s.Save("c", 3))
+	require.Nil(t, s.Save("a", 1))
+	require.Nil(t, s.Save("b", 2))
+	require.Nil(t, s.Alias("alias", "a"))
+
+	require.Equal(t, []string{"a", "b", "c"}, s.Keys())
+	require.Equal(t, 3, s.Len())
+
+	require.Nil(t, s.Delete("b"))
+	require.Equal(t, []string{"a", "c"}, s.Keys())
+	require.Equal(t, 2, s.Len())
+}
+
+func TestHas(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+	require.False(t, s.Has("key"))
+
+	require.Nil(t, s.Save("key", "value"))
+	require.Nil(t, s.Alias("alias", "key"))
+	require.True(t, s.Has("key"))
+	require.True(t, s.Has("alias"))
+
+	require.Nil(t, s.RegisterDefault("default", 1))
+	require.False(t, s.Has("default"))
+}
+
+func TestGetOrSet(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+
+	var result int
+	loaded, err := s.GetOrSet("key", &result, 1)
+	require.Nil(t, err)
+	require.False(t, loaded)
+	require.Equal(t, 1, result)
+
+	loaded, err = s.GetOrSet("key", &result, 2)
+	require.Nil(t, err)
+	require.True(t, loaded)
+	require.Equal(t, 1, result)
+
+	// The default is persisted
+	s, err = NewStash(filename, false)
+	require.Nil(t, err)
+	require.Nil(t, s.Read("key", &result))
+	require.Equal(t, 1, result)
+
+	_, err = s.GetOrSet("key", result, 2)
+	require.Equal(t, ErrInvalidDest, err)
+}
+
+func TestGetOrSetConcurrent(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+
+	var wg sync.WaitGroup
+	var stored int32
+	results := make([]int, 20)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			loaded, err := s.GetOrSet("key", &results[i], i)
+			assert.Nil(t, err)
+			if !loaded {
+				atomic.AddInt32(&stored, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	require.Equal(t, int32(1), stored)
+	for _, result := range results {
+		require.Equal(t, results[0], result)
+	}
+}
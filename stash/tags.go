@@ -0,0 +1,236 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"github.com/pkg/errors"
+	"sort"
+	"sync"
+)
+
+// SaveOptions holds optional settings for SaveWithOptions.
+type SaveOptions struct {
+	// Tags replaces the tags attached to the entry. Tags are free-form strings, saved
+	// with the data, that can be used to find entries with KeysByTag.
+	Tags []string
+}
+
+// tagTable holds the tags attached to each key. Like revisions, tags must only be
+// changed while holding the lock of the key's shard, so that they are removed
+// atomically with the key.
+type tagTable struct {
+	mutex sync.Mutex
+	tags  map[string][]string // sorted tags of each key
+}
+
+// get returns a copy of the tags of key.
+func (t *tagTable) get(key string) []string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return append([]string(nil), t.tags[key]...)
+}
+
+// update replaces the tags of key with the result of fn, which is given the current
+// tags as a set.
+func (t *tagTable) update(key string, fn func(tags map[string]bool)) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	set := make(map[string]bool)
+	for _, tag := range t.tags[key] {
+		set[tag] = true
+	}
+	fn(set)
+
+	if len(set) == 0 {
+		delete(t.tags, key)
+		return
+	}
+	tags := make([]string, 0, len(set))
+	for tag := range set {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	if t.tags == nil {
+		t.tags = make(map[string][]string)
+	}
+	t.tags[key] = tags
+}
+
+// remove forgets the tags of key, following its deletion.
+func (t *tagTable) remove(key string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.tags, key)
+}
+
+// keysWith returns the keys tagged with tag, in sorted order.
+func (t *tagTable) keysWith(tag string) []string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var keys []string
+	for key, tags := range t.tags {
+		if i := sort.SearchStrings(tags, tag); i < len(tags) && tags[i] == tag {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// load replaces the tags with those read from disk.
+func (t *tagTable) load(tags map[string][]string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.tags = tags
+}
+
+// snapshot returns a copy of the tags, for writing to disk.
+func (t *tagTable) snapshot() map[string][]string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if len(t.tags) == 0 {
+		return nil
+	}
+	tags := make(map[string][]string, len(t.tags))
+	for key, keyTags := range t.tags {
+		tags[key] = keyTags
+	}
+	return tags
+}
+
+// SaveWithOptions behaves like Save, but also applies opts to the entry. The value and
+// its tags are changed atomically. If auto-flush is enabled, the change is persisted to
+// disk immediately.
+func (s *Stash) SaveWithOptions(key string, value interface{}, opts SaveOptions) error {
+	marshalledData, codecName, err := s.marshal(value)
+	if err != nil {
+		return errors.Wrap(err, "error marshalling value")
+	}
+	if err = s.checkWritable(); err != nil {
+		return err
+	}
+	data, ok := s.data.(shardedData)
+	if !ok {
+		return UnknownVersionError{s.version}
+	}
+
+	key = s.aliases.resolve(key)
+	data.shardFor(key).update(func(data v1Data) {
+		data[key] = marshalledData
+		s.revisions.bump(key)
+		s.codecs.set(key, codecName)
+		s.tags.update(key, func(tags map[string]bool) {
+			for tag := range tags {
+				delete(tags, tag)
+			}
+			for _, tag := range opts.Tags {
+				tags[tag] = true
+			}
+		})
+	})
+	s.access.record(key, true)
+
+	// Tags are not journaled, so the whole store must be flushed
+	if s.autoFlush {
+		return s.Flush()
+	}
+	return nil
+}
+
+// Tags returns the tags attached to the key, in sorted order. It returns a
+// NoSuchKeyError if the key does not exist.
+func (s *Stash) Tags(key string) ([]string, error) {
+	var tags []string
+	err := s.withEntry(key, func(key string) {
+		tags = s.tags.get(key)
+	})
+	return tags, err
+}
+
+// AddTags attaches the tags to the key, in addition to any it already has. It returns a
+// NoSuchKeyError if the key does not exist. If auto-flush is enabled, the change is
+// persisted to disk immediately.
+func (s *Stash) AddTags(key string, tags ...string) error {
+	return s.changeTags(key, func(set map[string]bool) {
+		for _, tag := range tags {
+			set[tag] = true
+		}
+	})
+}
+
+// RemoveTags detaches the tags from the key. Tags the key does not have are ignored. It
+// returns a NoSuchKeyError if the key does not exist. If auto-flush is enabled, the
+// change is persisted to disk immediately.
+func (s *Stash) RemoveTags(key string, tags ...string) error {
+	return s.changeTags(key, func(set map[string]bool) {
+		for _, tag := range tags {
+			delete(set, tag)
+		}
+	})
+}
+
+// KeysByTag returns the keys tagged with tag, in sorted order.
+func (s *Stash) KeysByTag(tag string) []string {
+	return s.tags.keysWith(tag)
+}
+
+// changeTags modifies the tags of an existing key with fn, flushing if necessary.
+func (s *Stash) changeTags(key string, fn func(tags map[string]bool)) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	err := s.withEntry(key, func(key string) {
+		s.tags.update(key, fn)
+	})
+	if err != nil {
+		return err
+	}
+
+	if s.autoFlush {
+		return s.Flush()
+	}
+	return nil
+}
+
+// withEntry calls fn with the resolved key while holding the lock of its shard, so that
+// the key cannot be deleted while fn runs. It returns a NoSuchKeyError if the key does
+// not exist.
+func (s *Stash) withEntry(key string, fn func(key string)) error {
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
+	data, ok := s.data.(shardedData)
+	if !ok {
+		return UnknownVersionError{s.version}
+	}
+
+	key = s.aliases.resolve(key)
+	sh := data.shardFor(key)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+	if _, exists := sh.get()[key]; !exists {
+		return NoSuchKeyError{key}
+	}
+	fn(key)
+	return nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveWithTags(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+
+	require.Nil(t, s.SaveWithOptions("a", 1, SaveOptions{Tags: []string{"red", "big", "red"}}))
+	require.Nil(t, s.SaveWithOptions("b", 2, SaveOptions{Tags: []string{"red"}}))
+	require.Nil(t, s.Save("c", 3))
+
+	tags, err := s.Tags("a")
+	require.Nil(t, err)
+	require.Equal(t, []string{"big", "red"}, tags)
+	require.Equal(t, []string{"a", "b"}, s.KeysByTag("red"))
+	require.Empty(t, s.KeysByTag("missing"))
+
+	// Saving without options keeps the tags
+	require.Nil(t, s.Save("a", 10))
+	require.Equal(t, []string{"a"}, s.KeysByTag("big"))
+
+	// Tags are persisted
+	s, err = NewStash(filename, true)
+	require.Nil(t, err)
+	require.Equal(t, []string{"a", "b"}, s.KeysByTag("red"))
+
+	// Saving with options replaces the tags
+	require.Nil(t, s.SaveWithOptions("a", 1, SaveOptions{}))
+	tags, err = s.Tags("a")
+	require.Nil(t, err)
+	require.Empty(t, tags)
+}
+
+func TestAddRemoveTags(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+	require.Nil(t, s.Save("a", 1))
+	require.Nil(t, s.Alias("alias", "a"))
+
+	require.Nil(t, s.AddTags("alias", "x", "y"))
+	require.Nil(t, s.RemoveTags("a", "x", "z"))
+	tags, err := s.Tags("a")
+	require.Nil(t, err)
+	require.Equal(t, []string{"y"}, tags)
+
+	require.IsType(t, NoSuchKeyError{}, s.AddTags("missing", "x"))
+	require.IsType(t, NoSuchKeyError{}, s.RemoveTags("missing", "x"))
+	_, err = s.Tags("missing")
+	require.IsType(t, NoSuchKeyError{}, err)
+}
+
+func TestDeleteRemovesTags(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".journal")
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+	s.SetJournal(10)
+
+	require.Nil(t, s.SaveWithOptions("a", 1, SaveOptions{Tags: []string{"t"}}))
+	require.Nil(t, s.SaveWithOptions("b", 2, SaveOptions{Tags: []string{"t"}}))
+	require.Nil(t, s.Delete("a"))
+	require.Equal(t, []string{"b"}, s.KeysByTag("t"))
+
+	// The deletion was journaled, so replaying it must also drop the tags
+	s, err = NewStash(filename, true)
+	require.Nil(t, err)
+	require.Equal(t, []string{"b"}, s.KeysByTag("t"))
+
+	// Recreating the key does not bring back its old tags
+	require.Nil(t, s.Save("a", 1))
+	tags, err := s.Tags("a")
+	require.Nil(t, err)
+	require.Empty(t, tags)
+
+	require.Nil(t, s.Clear())
+	require.Empty(t, s.KeysByTag("t"))
+}
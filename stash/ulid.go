@@ -0,0 +1,101 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"crypto/rand"
+	"github.com/pkg/errors"
+	"io"
+)
+
+// crockford is the Crockford base32 alphabet used to encode ULIDs.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// maxSaveNewAttempts bounds the number of keys SaveNew generates before giving up. A
+// collision requires two keys created in the same millisecond with the same 80 random
+// bits, so in practice the first key is always used.
+const maxSaveNewAttempts = 10
+
+// randReader is the source of randomness for new keys. It is replaced in tests.
+var randReader io.Reader = rand.Reader
+
+// SaveNew saves the value under a newly generated key, which is returned. Keys are
+// ULIDs: 26 character strings that sort in order of creation, to the millisecond. This
+// is convenient for append-style data, where the key names are unimportant. SaveNew
+// never overwrites an existing key or alias.
+func (s *Stash) SaveNew(value interface{}) (key string, err error) {
+	marshalledData, codecName, err := s.marshal(value)
+	if err != nil {
+		return "", errors.Wrap(err, "error marshalling value")
+	}
+
+	for i := 0; i < maxSaveNewAttempts; i++ {
+		key, err = newULID()
+		if err != nil {
+			return "", err
+		}
+		if s.aliases.resolve(key) != key {
+			continue
+		}
+
+		saved, err := s.saveRawIf(key, marshalledData, codecName, func(exists bool) bool {
+			return !exists
+		})
+		if err != nil {
+			return "", err
+		} else if saved {
+			return key, nil
+		}
+	}
+	return "", errors.New("failed to generate a unique key")
+}
+
+// newULID returns a new ULID, made from the current time and random bits.
+func newULID() (string, error) {
+	var id [16]byte
+	ms := uint64(now().UnixNano() / 1e6)
+	for i := 5; i >= 0; i-- {
+		id[i] = byte(ms)
+		ms >>= 8
+	}
+	if _, err := io.ReadFull(randReader, id[6:]); err != nil {
+		return "", errors.Wrap(err, "failed to generate random key")
+	}
+	return encodeULID(id), nil
+}
+
+// encodeULID encodes the 128 bits of id as 26 base32 characters, most significant first.
+func encodeULID(id [16]byte) string {
+	var out [26]byte
+	// The first character holds only the top 3 bits, as 26 characters hold 130 bits
+	var acc uint32
+	bits := uint(2)
+	pos := 0
+	for _, b := range id {
+		acc = acc<<8 | uint32(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			out[pos] = crockford[(acc>>bits)&31]
+			pos++
+		}
+	}
+	return string(out[:])
+}
@@ -0,0 +1,99 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewULID(t *testing.T) {
+	defer func() { now, randReader = time.Now, randReaderDefault }()
+	now = func() time.Time { return time.Unix(0, 1469918176385*1e6) }
+	randReader = bytes.NewReader(make([]byte, 10))
+
+	id, err := newULID()
+	require.Nil(t, err)
+	require.Equal(t, "01ARYZ6S410000000000000000", id)
+}
+
+func TestSaveNew(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+
+	keys := make(map[string]bool)
+	var last string
+	for i := 0; i < 100; i++ {
+		key, err := s.SaveNew(i)
+		require.Nil(t, err)
+		require.Len(t, key, 26)
+		require.False(t, keys[key])
+		keys[key] = true
+
+		var out int
+		require.Nil(t, s.Read(key, &out))
+		require.Equal(t, i, out)
+
+		// Later keys never sort before earlier ones, to the millisecond
+		require.True(t, key[:10] >= last)
+		last = key[:10]
+	}
+}
+
+func TestSaveNewCollision(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	defer func() { now, randReader = time.Now, randReaderDefault }()
+	now = func() time.Time { return time.Unix(1000, 0) }
+	randReader = zeroReader{}
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+
+	key, err := s.SaveNew("first")
+	require.Nil(t, err)
+
+	// Every attempt generates the same key, so SaveNew must fail rather than overwrite
+	_, err = s.SaveNew("second")
+	require.NotNil(t, err)
+
+	var out string
+	require.Nil(t, s.Read(key, &out))
+	require.Equal(t, "first", out)
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+var randReaderDefault = randReader
@@ -0,0 +1,95 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+)
+
+// VerifyReport describes how the contents of a Stash differ from its file on disk.
+type VerifyReport struct {
+	MissingOnDisk   []string // keys held in memory but not found on disk
+	MissingInMemory []string // keys found on disk but not held in memory
+	Different       []string // keys whose values differ
+}
+
+// OK reports whether no differences were found.
+func (r VerifyReport) OK() bool {
+	return len(r.MissingOnDisk) == 0 && len(r.MissingInMemory) == 0 && len(r.Different) == 0
+}
+
+// Verify re-reads the file on disk, including any write journal, and compares it with
+// the contents of the Stash held in memory. This is useful after suspected disk problems
+// or external edits. An error is returned if the file cannot be read or decoded. Changes
+// that have not yet been flushed are reported as differences, so Verify is best called
+// straight after Flush. Keys in each list of the report are sorted.
+func (s *Stash) Verify() (VerifyReport, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var report VerifyReport
+	if err := s.checkOpen(); err != nil {
+		return report, err
+	}
+	_, onDisk, err := s.readFile(nil)
+	if err != nil {
+		return report, err
+	}
+
+	data, ok := s.data.(shardedData)
+	if !ok {
+		return report, UnknownVersionError{s.version}
+	}
+	return compareData(data.merged(), onDisk), nil
+}
+
+// compareData reports the differences between the data held in memory and on disk.
+func compareData(inMemory, onDisk v1Data) VerifyReport {
+	var report VerifyReport
+	for key, memoryValue := range inMemory {
+		diskValue, ok := onDisk[key]
+		if !ok {
+			report.MissingOnDisk = append(report.MissingOnDisk, key)
+		} else if !sameJSON(memoryValue, diskValue) {
+			report.Different = append(report.Different, key)
+		}
+	}
+	for key := range onDisk {
+		if _, ok := inMemory[key]; !ok {
+			report.MissingInMemory = append(report.MissingInMemory, key)
+		}
+	}
+
+	sort.Strings(report.MissingOnDisk)
+	sort.Strings(report.MissingInMemory)
+	sort.Strings(report.Different)
+	return report
+}
+
+// sameJSON reports whether a and b are the same JSON, ignoring insignificant whitespace.
+func sameJSON(a, b json.RawMessage) bool {
+	var compactA, compactB bytes.Buffer
+	if json.Compact(&compactA, a) != nil || json.Compact(&compactB, b) != nil {
+		return bytes.Equal(a, b)
+	}
+	return bytes.Equal(compactA.Bytes(), compactB.Bytes())
+}
@@ -0,0 +1,67 @@
+// Copyright 2017 Duncan Jones
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this
+// software and associated documentation files (the "Software"), to deal in the Software
+// without restriction, including without limitation the rights to use, copy, modify,
+// merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following
+// conditions:
+
+// The above copyright notice and this permission notice shall be included in all copies
+// or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+// INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+// PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+// HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF
+// CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package stash
+
+import (
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, false)
+	require.Nil(t, err)
+	require.Nil(t, s.Save("a", 1))
+	require.Nil(t, s.Save("b", 2))
+	require.Nil(t, s.Flush())
+
+	report, err := s.Verify()
+	require.Nil(t, err)
+	require.True(t, report.OK())
+
+	// Simulate an external edit
+	require.Nil(t, ioutil.WriteFile(filename, []byte(`{"Version":1,"Data":{"a": 1, "b":3,"c":4}}`), 0600))
+	require.Nil(t, s.Save("d", 5))
+
+	report, err = s.Verify()
+	require.Nil(t, err)
+	require.False(t, report.OK())
+	require.Equal(t, VerifyReport{
+		MissingOnDisk:   []string{"d"},
+		MissingInMemory: []string{"c"},
+		Different:       []string{"b"},
+	}, report)
+}
+
+func TestVerifyBadFile(t *testing.T) {
+	filename := makeTempFilename()
+	defer os.Remove(filename)
+
+	s, err := NewStash(filename, true)
+	require.Nil(t, err)
+
+	require.Nil(t, ioutil.WriteFile(filename, []byte("foobarbaz"), 0600))
+	_, err = s.Verify()
+	require.NotNil(t, err)
+}